@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pachyderm/config-pod/configpod"
+	log "github.com/sirupsen/logrus"
+)
+
+// exitDrift is check's exit code when the cluster has drifted from the
+// config, distinct from failing (1) and invalid usage (2)
+const exitDrift = 3
+
+// driftedItem is an item which differs from the config
+type driftedItem struct {
+	Step   string
+	Target string
+	configpod.ItemResult
+}
+
+// driftedItems returns the items in a dry run's report which differ from the config
+func driftedItems(r *configpod.Result) []driftedItem {
+	var drifted []driftedItem
+	for _, step := range r.Steps {
+		for _, item := range step.Items {
+			if item.Drifted() {
+				drifted = append(drifted, driftedItem{Step: step.Name, Target: step.Target, ItemResult: item})
+			}
+		}
+	}
+	return drifted
+}
+
+// checkDrift prints the drift in a dry run's report of the config in root,
+// and returns check's exit code: exitDrift if anything has drifted, else 0
+func checkDrift(w io.Writer, root string, r *configpod.Result) int {
+	drifted := driftedItems(r)
+	printDrift(w, root, drifted)
+	if len(drifted) > 0 {
+		log.WithField("count", len(drifted)).Warn("cluster has drifted from the config")
+		return exitDrift
+	}
+	return 0
+}
+
+// printDrift writes the drifted items from the config in root, with the
+// change apply would make to each
+func printDrift(w io.Writer, root string, drifted []driftedItem) {
+	if len(drifted) == 0 {
+		fmt.Fprintf(w, "No drift: the cluster matches the config in %s.\n", root)
+		return
+	}
+	fmt.Fprintf(w, "Drift: %d item(s) differ from the config in %s:\n", len(drifted), root)
+	for _, d := range drifted {
+		fmt.Fprintf(w, "  %s %s %q (%s, in step %q", d.Action.Symbol(), d.Kind, d.ID, d.Action, d.Step)
+		if d.Target != "" {
+			fmt.Fprintf(w, " on target %q", d.Target)
+		}
+		fmt.Fprintln(w, ")")
+	}
+}
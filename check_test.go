@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pachyderm/config-pod/configpod"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriftedItems(t *testing.T) {
+	r := &configpod.Result{DryRun: true, Steps: []configpod.StepResult{
+		{Name: "enterprise secret", Outcome: configpod.OutcomePlanned, Items: []configpod.ItemResult{
+			{Kind: "enterprise cluster", ID: "localhost", Action: configpod.ActionUnchanged},
+			// the enterprise config can't be compared, so an update isn't drift
+			{Kind: "enterprise config", ID: "localhost", Action: configpod.ActionUpdate},
+		}},
+		{Name: "sync identity providers", Outcome: configpod.OutcomePlanned, Items: []configpod.ItemResult{
+			{Kind: "idp connector", ID: "github", Action: configpod.ActionUpdate},
+			{Kind: "idp connector", ID: "test", Action: configpod.ActionDelete},
+		}},
+	}}
+
+	drifted := driftedItems(r)
+	require.Equal(t, []driftedItem{
+		{Step: "sync identity providers", ItemResult: configpod.ItemResult{Kind: "idp connector", ID: "github", Action: configpod.ActionUpdate}},
+		{Step: "sync identity providers", ItemResult: configpod.ItemResult{Kind: "idp connector", ID: "test", Action: configpod.ActionDelete}},
+	}, drifted)
+
+	var out strings.Builder
+	printDrift(&out, "/pachConfig", drifted)
+	require.Equal(t, `Drift: 2 item(s) differ from the config in /pachConfig:
+  ~ idp connector "github" (update, in step "sync identity providers")
+  - idp connector "test" (delete, in step "sync identity providers")
+`, out.String())
+
+	out.Reset()
+	printDrift(&out, "/pachConfig", nil)
+	require.Equal(t, "No drift: the cluster matches the config in /pachConfig.\n", out.String())
+}
+
+// TestCheckUndeclared tests that check exits with exitDrift when an item has
+// been added outside of config-pod, even though its kind isn't pruned
+func TestCheckUndeclared(t *testing.T) {
+	r := &configpod.Result{DryRun: true, Steps: []configpod.StepResult{
+		{Name: "sync identity providers", Outcome: configpod.OutcomePlanned, Items: []configpod.ItemResult{
+			{Kind: "idp connector", ID: "github", Action: configpod.ActionUnchanged},
+			{Kind: "idp connector", ID: "added", Action: configpod.ActionUndeclared},
+		}},
+	}}
+
+	var out strings.Builder
+	require.Equal(t, exitDrift, checkDrift(&out, "/pachConfig", r))
+	require.Equal(t, `Drift: 1 item(s) differ from the config in /pachConfig:
+  ? idp connector "added" (undeclared, in step "sync identity providers")
+`, out.String())
+
+	r.Steps[0].Items = r.Steps[0].Items[:1]
+	out.Reset()
+	require.Equal(t, 0, checkDrift(&out, "/pachConfig", r))
+}
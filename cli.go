@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pachyderm/config-pod/configpod"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// version is config-pod's release, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+const usage = `Usage: config-pod [command] [flags]
+
+Commands:
+  apply             apply the config to the cluster (the default)
+  plan              report what apply would change, without changing it
+  check             report items which have drifted from the config, exiting 3 if any have
+  validate [dir]    check the config without connecting to the cluster
+  export            write the cluster's current state as config files or a Secret
+  version           print the version
+
+Run 'config-pod <command> -h' for the command's flags. Every flag defaults to
+the environment variable in its description.
+`
+
+// options are the flags shared by the commands. Each defaults to an
+// environment variable, which is how the Job configures config-pod.
+type options struct {
+	configRoot     string
+	pachAddr       string
+	enterpriseAddr string
+	only           string
+	skip           string
+	logFormat      string
+	// token authenticates with pachd and the enterprise server in place of
+	// the config root's root tokens, if it's set
+	token string
+}
+
+// envOr returns the value of an environment variable, or def if it's unset or empty
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// newFlagSet returns the flags for a command. Commands which connect to
+// pachd also get the connection flags.
+func newFlagSet(cmd string, opts *options, connect bool) *flag.FlagSet {
+	fs := flag.NewFlagSet("config-pod "+cmd, flag.ContinueOnError)
+	fs.StringVar(&opts.configRoot, "config-root", envOr("PACH_CONFIG_ROOT", "/pachConfig"), "directory containing the config (PACH_CONFIG_ROOT)")
+	fs.StringVar(&opts.logFormat, "log-format", envOr("PACH_LOG_FORMAT", "text"), "log format, text or json (PACH_LOG_FORMAT)")
+	if connect {
+		fs.StringVar(&opts.pachAddr, "pach-addr", envOr("PACH_ADDR", "grpc://pachd-peer:30653"), "address of pachd (PACH_ADDR)")
+		fs.StringVar(&opts.enterpriseAddr, "enterprise-addr", os.Getenv("PACH_ENTERPRISE_ADDR"), "address of the enterprise server, overriding the enterpriseServerAddress key (PACH_ENTERPRISE_ADDR)")
+	}
+	return fs
+}
+
+// addStepFlags adds the flags which select the steps to run
+func addStepFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.only, "only", os.Getenv("PACH_CONFIG_ONLY"), "comma-separated steps to run, skipping every other step (PACH_CONFIG_ONLY)")
+	fs.StringVar(&opts.skip, "skip", os.Getenv("PACH_CONFIG_SKIP"), "comma-separated steps to skip (PACH_CONFIG_SKIP)")
+}
+
+// parseArgs splits the command from its arguments, defaulting to apply so
+// that running config-pod with no arguments, as the Job does, applies the config
+func parseArgs(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "apply", args
+	}
+	return args[0], args[1:]
+}
+
+// boolFromEnv parses an optional boolean environment variable
+func boolFromEnv(name string) (bool, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// durationFromEnv parses an optional duration environment variable, such as "30s"
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// setLogFormat configures logrus for the --log-format flag
+func setLogFormat(format string) error {
+	switch format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, ignoring empty entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// printUsage writes the list of commands, and the names of the steps for --only and --skip
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, usage)
+	fmt.Fprintln(w, "\nSteps, for --only and --skip:")
+	for _, name := range configpod.StepNames() {
+		fmt.Fprintf(w, "  %s\n", name)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	cmd, args := parseArgs(nil)
+	require.Equal(t, "apply", cmd)
+	require.Equal(t, 0, len(args))
+
+	cmd, args = parseArgs([]string{"--config-root", "/tmp/config"})
+	require.Equal(t, "apply", cmd)
+	require.Equal(t, []string{"--config-root", "/tmp/config"}, args)
+
+	cmd, args = parseArgs([]string{"validate", "/tmp/config"})
+	require.Equal(t, "validate", cmd)
+	require.Equal(t, []string{"/tmp/config"}, args)
+}
+
+func TestFlagDefaults(t *testing.T) {
+	require.NoError(t, os.Setenv("PACH_ADDR", "grpc://env:30650"))
+	defer os.Unsetenv("PACH_ADDR")
+
+	var opts options
+	require.NoError(t, newFlagSet("apply", &opts, true).Parse(nil))
+	require.Equal(t, "grpc://env:30650", opts.pachAddr)
+
+	require.NoError(t, newFlagSet("apply", &opts, true).Parse([]string{"--pach-addr", "grpc://flag:30650"}))
+	require.Equal(t, "grpc://flag:30650", opts.pachAddr)
+}
@@ -0,0 +1,179 @@
+package configpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// These are the keys for the config secret
+	rootTokenPath             = "rootToken"
+	enterpriseRootTokenPath   = "enterpriseRootToken"
+	enterpriseServerAddress   = "enterpriseServerAddress"
+	licensePath               = "license"
+	enterpriseSecretPath      = "enterpriseSecret"
+	enterpriseClustersPath    = "enterpriseClusters"
+	enterpriseConfigPath      = "enterpriseConfig"
+	clusterRoleBindingsPath   = "clusterRoleBindings"
+	identityServiceConfigPath = "identityServiceConfig"
+	idpsPath                  = "idps"
+	oidcClientsPath           = "oidcClients"
+	authConfigPath            = "authConfig"
+	prunePath                 = "prune"
+	repoRoleBindingsPath      = "repoRoleBindings"
+	reposPath                 = "repos"
+	pipelinesPath             = "pipelines"
+)
+
+// ErrSkipped is returned when a config key isn't set. A step which returns
+// it is reported as skipped rather than failed.
+var ErrSkipped = errors.New("skipped step")
+
+// Config is a config root: a directory with a file for each config key,
+// usually a mounted Kubernetes Secret
+type Config struct {
+	// root is the config's directory, if it's read from disk
+	root string
+	fsys fs.FS
+
+	// vault is shared by the secret references resolved in a run, if set
+	vault *vaultSession
+}
+
+// NewConfig returns the config in the directory root
+func NewConfig(root string) *Config {
+	return &Config{root: root, fsys: os.DirFS(root)}
+}
+
+// NewConfigFS returns the config in fsys, for config which isn't in a
+// directory on disk, such as a Secret read from the Kubernetes API into an
+// fstest.MapFS. Relative file: references are read from fsys.
+func NewConfigFS(fsys fs.FS) *Config {
+	return &Config{fsys: fsys}
+}
+
+// forRun returns a copy of the config for a single run of the steps, which
+// shares a Vault client between the run's secret references
+func (c *Config) forRun() *Config {
+	run := *c
+	run.vault = &vaultSession{}
+	return &run
+}
+
+// Root is the config's directory, which is empty for a config from NewConfigFS
+func (c *Config) Root() string {
+	return c.root
+}
+
+// name is how errors refer to a config file, given its path in the config
+func (c *Config) name(path string) string {
+	return filepath.Join(c.root, filepath.FromSlash(path))
+}
+
+// RootToken returns pachd's root token, resolving it if it's a secret
+// reference, or an ErrSkipped if it isn't set
+func (c *Config) RootToken(ctx context.Context) (string, error) {
+	token, err := c.skipIfNotExistResolvable(ctx, rootTokenPath)
+	return string(token), err
+}
+
+// EnterpriseRootToken returns the enterprise server's root token, like RootToken
+func (c *Config) EnterpriseRootToken(ctx context.Context) (string, error) {
+	token, err := c.skipIfNotExistResolvable(ctx, enterpriseRootTokenPath)
+	return string(token), err
+}
+
+// EnterpriseServerAddress returns the address of the enterprise server, or
+// an ErrSkipped if pachd is its own enterprise server
+func (c *Config) EnterpriseServerAddress() (string, error) {
+	addr, err := c.skipIfNotExist(enterpriseServerAddress)
+	return string(addr), err
+}
+
+// skipIfNotExist loads the contents of a config file, or returns
+// an ErrSkipped if the file doesn't exist.
+func (c *Config) skipIfNotExist(path string) ([]byte, error) {
+	data, err := c.readFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w - no file %s", ErrSkipped, c.name(path))
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// readFile reads a config file and interpolates environment variables into it
+func (c *Config) readFile(path string) ([]byte, error) {
+	data, err := fs.ReadFile(c.fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = interpolate(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name(path), err)
+	}
+	return data, nil
+}
+
+func (c *Config) skipIfNotExistResolvable(ctx context.Context, path string) ([]byte, error) {
+	v, err := c.skipIfNotExist(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.resolveData(ctx, v)
+}
+
+// resolveData resolves a config file containing a single value, which may be
+// a secret reference
+func (c *Config) resolveData(ctx context.Context, v []byte) ([]byte, error) {
+	vStr, err := c.resolveValue(ctx, string(v))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(vStr), nil
+}
+
+// loadYAML loads a config file into out, resolving secret references in
+// every string field
+func (c *Config) loadYAML(ctx context.Context, path string, out interface{}) error {
+	data, err := c.skipIfNotExist(path)
+	if err != nil {
+		return err
+	}
+	return c.parseYAML(ctx, c.name(path), data, out)
+}
+
+// parseYAML is like loadYAML for config which has already been read, where
+// name describes where it was read from
+func (c *Config) parseYAML(ctx context.Context, name string, data []byte, out interface{}) error {
+	data, err := c.resolveDocument(ctx, data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return err
+	}
+	if err := c.resolveFields(ctx, out); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// resolveDocument resolves a config file whose whole content is a secret
+// reference, such as $ROLE_BINDINGS, to the document it references. Any other
+// document is returned unchanged.
+func (c *Config) resolveDocument(ctx context.Context, data []byte) ([]byte, error) {
+	value := strings.TrimRight(string(data), "\r\n")
+	if !isReference(value) {
+		return data, nil
+	}
+	return c.resolveData(ctx, []byte(value))
+}
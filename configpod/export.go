@@ -0,0 +1,292 @@
+package configpod
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+	"github.com/pachyderm/pachyderm/v2/src/client"
+	"github.com/pachyderm/pachyderm/v2/src/identity"
+	"github.com/pachyderm/pachyderm/v2/src/license"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+// RedactedSecret replaces secrets in a redacted export
+const RedactedSecret = "REDACTED"
+
+// secretJSONKeys match the fields of an IDP connector's JSON config which
+// hold secrets, such as clientSecret or bindPW
+var secretJSONKeys = regexp.MustCompile(`(?i)secret|password|token|bindpw`)
+
+var nonEnvVarChars = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// Exporter reads the cluster's state into config files. Secrets are either
+// redacted, or replaced with environment variable references so that the
+// export can be applied once the variables are set. pachd doesn't return
+// some secrets, such as cluster secrets, so these are always replaced.
+type Exporter struct {
+	Redact bool
+	// EnvVars are the variables referenced in place of secrets
+	EnvVars []string
+	// Logger is where the keys which aren't exported are logged. It's the
+	// standard logger if nil.
+	Logger *log.Entry
+}
+
+func (e *Exporter) log() *log.Entry {
+	if e.Logger == nil {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return e.Logger
+}
+
+// secret returns the value exported in place of a secret field, where name
+// describes the secret, e.g. "oidc client pachd secret"
+func (e *Exporter) secret(name string) string {
+	if e.Redact {
+		return RedactedSecret
+	}
+	return "env:" + e.envVar(name)
+}
+
+// embeddedSecret is like secret, for a secret inside a larger value such as
+// an IDP connector's JSON config, which is interpolated rather than resolved
+func (e *Exporter) embeddedSecret(name string) string {
+	if e.Redact {
+		return RedactedSecret
+	}
+	return "${" + e.envVar(name) + "}"
+}
+
+func (e *Exporter) envVar(name string) string {
+	v := strings.Trim(nonEnvVarChars.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+	e.EnvVars = append(e.EnvVars, v)
+	return v
+}
+
+// Export reads each config key that can be read back from pachd, and returns
+// the contents of its file in the format the config root is read in. Keys
+// for services which haven't been activated are left out.
+func (e *Exporter) Export(c *client.APIClient, ec *client.APIClient) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	exports := []struct {
+		path string
+		fn   func() (interface{}, error)
+	}{
+		{enterpriseClustersPath, func() (interface{}, error) { return e.enterpriseClusters(ec) }},
+		{oidcClientsPath, func() (interface{}, error) { return e.oidcClients(ec) }},
+		{idpsPath, func() (interface{}, error) { return e.idps(ec) }},
+		{authConfigPath, func() (interface{}, error) { return e.authConfig(c) }},
+		{identityServiceConfigPath, func() (interface{}, error) { return e.identityServiceConfig(c) }},
+		{clusterRoleBindingsPath, func() (interface{}, error) { return e.clusterRoleBindings(c) }},
+	}
+	for _, x := range exports {
+		value, err := x.fn()
+		if err != nil {
+			if isErrNotActivated(err) {
+				e.log().WithField("key", x.path).WithField("reason", err).Info("not exported")
+				continue
+			}
+			return nil, fmt.Errorf("could not export %s: %w", x.path, err)
+		}
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		files[x.path] = data
+	}
+
+	// the enterprise config isn't returned by pachd
+	e.log().WithField("key", enterpriseConfigPath).Warn("not exported, the enterprise config can't be read from pachd")
+	return files, nil
+}
+
+func (e *Exporter) enterpriseClusters(ec *client.APIClient) ([]license.AddClusterRequest, error) {
+	clusters, err := ec.License.ListClusters(ec.Ctx(), &license.ListClustersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	userClusters, err := ec.License.ListUserClusters(ec.Ctx(), &license.ListUserClustersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	userInfo := make(map[string]*license.UserClusterInfo)
+	for _, info := range userClusters.Clusters {
+		userInfo[info.Id] = info
+	}
+
+	result := []license.AddClusterRequest{}
+	for _, cluster := range clusters.Clusters {
+		// the embedded enterprise server registers itself, with a secret only
+		// pachd knows, and is never pruned, so it isn't config-pod's to manage
+		if cluster.Id == localhostEnterpriseClusterId {
+			continue
+		}
+		r := license.AddClusterRequest{
+			Id:      cluster.Id,
+			Address: cluster.Address,
+			Secret:  e.secret("enterprise cluster " + cluster.Id + " secret"),
+		}
+		// the enterprise server isn't listed as a user cluster
+		if info, ok := userInfo[cluster.Id]; ok {
+			r.UserAddress = info.Address
+			r.ClusterDeploymentId = info.ClusterDeploymentId
+		} else {
+			r.EnterpriseServer = true
+		}
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result, nil
+}
+
+func (e *Exporter) oidcClients(ec *client.APIClient) ([]identity.OIDCClient, error) {
+	resp, err := ec.ListOIDCClients(ec.Ctx(), &identity.ListOIDCClientsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	result := []identity.OIDCClient{}
+	for _, client := range resp.Clients {
+		client.Secret = e.secret("oidc client " + client.Id + " secret")
+		result = append(result, *client)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result, nil
+}
+
+func (e *Exporter) idps(ec *client.APIClient) ([]identity.IDPConnector, error) {
+	resp, err := ec.ListIDPConnectors(ec.Ctx(), &identity.ListIDPConnectorsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	result := []identity.IDPConnector{}
+	for _, connector := range resp.Connectors {
+		config, err := e.redactJSONConfig("idp "+connector.Id, connector.JsonConfig)
+		if err != nil {
+			return nil, fmt.Errorf("idp %s: %w", connector.Id, err)
+		}
+		connector.JsonConfig = config
+		// the config version is managed by idpsStep
+		connector.ConfigVersion = 0
+		result = append(result, *connector)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result, nil
+}
+
+// redactJSONConfig replaces the secret fields of an IDP connector's JSON config
+func (e *Exporter) redactJSONConfig(name, config string) (string, error) {
+	if config == "" {
+		return "", nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &fields); err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := fields[k].(string); ok && secretJSONKeys.MatchString(k) {
+			fields[k] = e.embeddedSecret(name + " " + k)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	// leave characters such as & in URLs as they are
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(fields); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func (e *Exporter) authConfig(c *client.APIClient) (*auth.OIDCConfig, error) {
+	resp, err := c.GetConfiguration(c.Ctx(), &auth.GetConfigurationRequest{})
+	if err != nil {
+		return nil, err
+	}
+	config := resp.Configuration
+	if config.ClientSecret != "" {
+		config.ClientSecret = e.secret("auth config client secret")
+	}
+	return config, nil
+}
+
+func (e *Exporter) identityServiceConfig(c *client.APIClient) (*identity.IdentityServerConfig, error) {
+	resp, err := c.GetIdentityServerConfig(c.Ctx(), &identity.GetIdentityServerConfigRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Config, nil
+}
+
+// clusterRoleBindings exports the cluster role binding, except for `pach:`
+// principals, which are managed by pachd and can't be modified
+func (e *Exporter) clusterRoleBindings(c *client.APIClient) (map[string][]string, error) {
+	resp, err := c.GetRoleBinding(c.Ctx(), &auth.GetRoleBindingRequest{
+		Resource: &auth.Resource{Type: auth.ResourceType_CLUSTER},
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	for p, roles := range resp.Binding.Entries {
+		if strings.HasPrefix(p, auth.PachPrefix) {
+			continue
+		}
+		for r := range roles.Roles {
+			result[p] = append(result[p], r)
+		}
+		sort.Strings(result[p])
+	}
+	return result, nil
+}
+
+// WriteConfigDir writes each file into dir, as the config root
+func WriteConfigDir(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for path, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, path), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSecretManifest writes a Kubernetes Secret with each file as a key,
+// in the same form as examples/full-secret.yaml
+func WriteSecretManifest(w io.Writer, name string, files map[string][]byte) error {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: %s\nstringData:\n", name)
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "  %s: |\n", path)
+		for _, line := range strings.SplitAfter(strings.TrimRight(string(files[path]), "\n"), "\n") {
+			fmt.Fprintf(&buf, "    %s", line)
+		}
+		buf.WriteString("\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,94 @@
+package configpod
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate substitutes environment variables into the contents of a
+// config file, other than a pipeline spec:
+//
+//	${VAR}          the value of VAR, which must be set
+//	${VAR:-default} the value of VAR, or default if VAR is unset or empty
+//	${VAR:?message} the value of VAR, or an error with message if VAR is unset or empty
+//	$${             a literal "${"
+//
+// Any other "$" is left alone, so whole-value references like "$VAR" are
+// still resolved later by resolveValue.
+func interpolate(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	for {
+		i := bytes.Index(data, []byte("${"))
+		if i < 0 {
+			out.Write(data)
+			return out.Bytes(), nil
+		}
+
+		// "$${" escapes a literal "${"
+		if i > 0 && data[i-1] == '$' {
+			out.Write(data[:i-1])
+			out.WriteString("${")
+			data = data[i+2:]
+			continue
+		}
+
+		end := bytes.IndexByte(data[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated ${ at %q", truncate(string(data[i:]), 20))
+		}
+
+		value, err := expandVar(string(data[i+2 : i+end]))
+		if err != nil {
+			return nil, err
+		}
+		out.Write(data[:i])
+		out.WriteString(value)
+		data = data[i+end+1:]
+	}
+}
+
+// expandVar expands the expression inside ${...}
+func expandVar(expr string) (string, error) {
+	name, op, arg := expr, "", ""
+	if i := strings.Index(expr, ":"); i >= 0 {
+		if len(expr) < i+2 || (expr[i+1] != '-' && expr[i+1] != '?') {
+			return "", fmt.Errorf("invalid substitution ${%s}, expected ${VAR}, ${VAR:-default} or ${VAR:?message}", expr)
+		}
+		name, op, arg = expr[:i], expr[i:i+2], expr[i+2:]
+	}
+	if !envVarName.MatchString(name) {
+		return "", fmt.Errorf("invalid variable name %q in ${%s}", name, expr)
+	}
+
+	value, isset := os.LookupEnv(name)
+	switch op {
+	case ":-":
+		if value == "" {
+			return arg, nil
+		}
+	case ":?":
+		if value == "" {
+			if arg == "" {
+				arg = "is not set"
+			}
+			return "", fmt.Errorf("environment variable %s: %s", name, arg)
+		}
+	default:
+		if !isset {
+			return "", fmt.Errorf("expected environment variable, %s, is not set", name)
+		}
+	}
+	return value, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n] + "..."
+	}
+	return s
+}
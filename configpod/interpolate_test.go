@@ -0,0 +1,46 @@
+package configpod
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolate(t *testing.T) {
+	require.NoError(t, os.Setenv("INTERPOLATE_HOST", "pachd"))
+	require.NoError(t, os.Setenv("INTERPOLATE_EMPTY", ""))
+	defer os.Unsetenv("INTERPOLATE_HOST")
+	defer os.Unsetenv("INTERPOLATE_EMPTY")
+
+	for input, expected := range map[string]string{
+		"issuer: http://${INTERPOLATE_HOST}:1658/":                           "issuer: http://pachd:1658/",
+		"${INTERPOLATE_HOST}${INTERPOLATE_HOST}":                             "pachdpachd",
+		"port: ${INTERPOLATE_PORT:-1658}":                                    "port: 1658",
+		"port: ${INTERPOLATE_EMPTY:-1658}":                                   "port: 1658",
+		"host: ${INTERPOLATE_HOST:-localhost}":                               "host: pachd",
+		"host: ${INTERPOLATE_HOST:?host is required}":                        "host: pachd",
+		"empty: '${INTERPOLATE_EMPTY}'":                                      "empty: ''",
+		"cmd: echo $${PACH_JOB_ID}":                                          "cmd: echo ${PACH_JOB_ID}",
+		"secret: $TEST_SECRET":                                               "secret: $TEST_SECRET",
+		"price: $5, no braces":                                               "price: $5, no braces",
+		"redirect: http://${INTERPOLATE_HOST:-x}/a?b=${INTERPOLATE_PORT:-1}": "redirect: http://pachd/a?b=1",
+	} {
+		output, err := interpolate([]byte(input))
+		require.NoError(t, err, input)
+		require.Equal(t, expected, string(output), input)
+	}
+
+	for input, message := range map[string]string{
+		"${INTERPOLATE_UNSET}":                 "INTERPOLATE_UNSET, is not set",
+		"${INTERPOLATE_UNSET:?host is needed}": "host is needed",
+		"${INTERPOLATE_EMPTY:?}":               "is not set",
+		"${INTERPOLATE_HOST":                   "unterminated",
+		"${INTERPOLATE_HOST:=x}":               "invalid substitution",
+		"${not a name}":                        "invalid variable name",
+	} {
+		_, err := interpolate([]byte(input))
+		require.Error(t, err, input)
+		require.Contains(t, err.Error(), message, input)
+	}
+}
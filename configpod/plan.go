@@ -0,0 +1,156 @@
+package configpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Action is what a step did, or would do in dry-run mode, to a single
+// item of cluster state
+type Action string
+
+const (
+	ActionCreate    Action = "create"
+	ActionUpdate    Action = "update"
+	ActionDelete    Action = "delete"
+	ActionUnchanged Action = "unchanged"
+	// ActionUndeclared is an item in the cluster which isn't in the config,
+	// and which is left alone because its kind isn't pruned. It's never
+	// applied, but it's still drift.
+	ActionUndeclared Action = "undeclared"
+)
+
+// These are the kinds of items managed by the sync steps
+const (
+	kindLicense            = "license"
+	kindEnterpriseCluster  = "enterprise cluster"
+	kindEnterpriseConfig   = "enterprise config"
+	kindAuth               = "auth"
+	kindIdentityConfig     = "identity config"
+	kindOIDCClient         = "oidc client"
+	kindAuthConfig         = "auth config"
+	kindIDPConnector       = "idp connector"
+	kindClusterRoleBinding = "cluster role binding"
+	kindRepoRoleBinding    = "repo role binding"
+	kindRepo               = "repo"
+	kindPipeline           = "pipeline"
+)
+
+type change struct {
+	Kind   string
+	ID     string
+	Action Action
+}
+
+// stepState is passed to every sync step. It collects the per-item changes
+// the step makes and, in dry-run mode, tells the step not to make them.
+type stepState struct {
+	dryRun bool
+	// config is the config root the step reads its config from
+	config  *Config
+	changes []change
+	// completed are the changes which have been applied, so that an
+	// interrupted step can report how far it got
+	completed []change
+	// target is the pachd a cluster-scoped step is applied to, whose config
+	// it reads with loadYAML. It's nil for enterprise-scoped steps.
+	target *Target
+	// logger is where the step logs, so that concurrent steps' logs can be
+	// written in order. It's the standard logger if nil.
+	logger *log.Entry
+}
+
+// log returns the step's logger
+func (s *stepState) log() *log.Entry {
+	if s.logger == nil {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return s.logger
+}
+
+// apply records a change and calls fn to make it, unless the item is
+// unchanged or undeclared, or this is a dry run. It returns an error without
+// starting the change if ctx has been cancelled.
+func (s *stepState) apply(ctx context.Context, kind, id string, action Action, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("interrupted before %s %q: %w", kind, id, err)
+	}
+	c := change{Kind: kind, ID: id, Action: action}
+	s.changes = append(s.changes, c)
+	if s.dryRun || action == ActionUnchanged || action == ActionUndeclared {
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	s.completed = append(s.completed, c)
+	return nil
+}
+
+// diffAction returns the action needed to bring an item to its desired state
+func diffAction(exists, equal bool) Action {
+	if !exists {
+		return ActionCreate
+	}
+	if equal {
+		return ActionUnchanged
+	}
+	return ActionUpdate
+}
+
+var actionSymbols = map[Action]string{
+	ActionCreate:     "+",
+	ActionUpdate:     "~",
+	ActionDelete:     "-",
+	ActionUnchanged:  "=",
+	ActionUndeclared: "?",
+}
+
+// Symbol returns the symbol plans show the action with, e.g. + for create
+func (a Action) Symbol() string {
+	return actionSymbols[a]
+}
+
+// printPlan writes the changes a step would make in a human-readable form
+func printPlan(w io.Writer, stepName string, changes []change, err error) {
+	if err != nil {
+		if errors.Is(err, ErrSkipped) {
+			fmt.Fprintf(w, "%s: skipped (%v)\n", stepName, err)
+		} else {
+			fmt.Fprintf(w, "%s: failed (%v)\n", stepName, err)
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "%s:\n", stepName)
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %s %s %q (%s)\n", c.Action.Symbol(), c.Kind, c.ID, c.Action)
+	}
+}
+
+// describeChanges formats changes for logging, e.g. `create repo "images"`
+func describeChanges(changes []change) []string {
+	descs := make([]string, 0, len(changes))
+	for _, c := range changes {
+		descs = append(descs, fmt.Sprintf("%s %s %q", c.Action, c.Kind, c.ID))
+	}
+	return descs
+}
+
+// printPlanSummary writes the total number of changes in a plan
+func printPlanSummary(w io.Writer, changes []change) {
+	counts := make(map[Action]int)
+	for _, c := range changes {
+		counts[c.Action]++
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete, %d unchanged",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete], counts[ActionUnchanged])
+	if counts[ActionUndeclared] > 0 {
+		fmt.Fprintf(w, ", %d undeclared and not pruned", counts[ActionUndeclared])
+	}
+	fmt.Fprintln(w, ".")
+}
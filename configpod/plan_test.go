@@ -0,0 +1,39 @@
+package configpod
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestApplyInterrupted tests that a step stops before its next item once its
+// context is cancelled, and records the items it had already applied
+func TestApplyInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st := &stepState{}
+	require.NoError(t, st.apply(ctx, kindRepo, "images", ActionCreate, func() error { return nil }))
+	require.NoError(t, st.apply(ctx, kindRepo, "edges", ActionUnchanged, func() error {
+		t.Fatal("unchanged item was applied")
+		return nil
+	}))
+	require.NoError(t, st.apply(ctx, kindRepo, "montage", ActionUpdate, func() error {
+		cancel()
+		return nil
+	}))
+
+	err := st.apply(ctx, kindRepo, "thumbnails", ActionCreate, func() error {
+		t.Fatal("item was applied after the context was cancelled")
+		return nil
+	})
+	require.True(t, errors.Is(err, context.Canceled))
+
+	require.Equal(t, []change{
+		{Kind: kindRepo, ID: "images", Action: ActionCreate},
+		{Kind: kindRepo, ID: "montage", Action: ActionUpdate},
+	}, st.completed)
+	require.Equal(t, []string{`create repo "images"`, `update repo "montage"`}, describeChanges(st.completed))
+}
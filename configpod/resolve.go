@@ -0,0 +1,214 @@
+package configpod
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// A resolver turns a secret reference, the part of a config value after its
+// "scheme:" prefix, into the secret itself. c is the config the value is
+// from, and ctx limits how long resolving it may take.
+type resolver func(ctx context.Context, c *Config, ref string) (string, error)
+
+// resolvers are the secret reference schemes understood by resolveValue
+var resolvers = map[string]resolver{
+	"env":    resolveEnv,
+	"file":   resolveFile,
+	"base64": resolveBase64,
+	"exec":   resolveExec,
+}
+
+// registerResolver adds a secret reference scheme
+func registerResolver(scheme string, r resolver) {
+	resolvers[scheme] = r
+}
+
+// resolveValue resolves a config value which may reference a secret. A value
+// starting with "$" names an environment variable, and a value starting with
+// a registered "scheme:" is passed to that scheme's resolver. Anything else
+// is returned unchanged.
+func (c *Config) resolveValue(ctx context.Context, v string) (string, error) {
+	if strings.HasPrefix(v, "$") {
+		return resolveEnv(ctx, c, strings.TrimPrefix(v, "$"))
+	}
+
+	scheme, ref, ok := splitReference(v)
+	if !ok {
+		return v, nil
+	}
+	resolved, err := resolvers[scheme](ctx, c, ref)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s reference: %w", scheme, err)
+	}
+	return resolved, nil
+}
+
+// resolveFields resolves secret references in every string reachable from v,
+// which must be a pointer, by walking struct fields, slices, arrays, maps and
+// interfaces. Unexported fields and map keys are left alone.
+func (c *Config) resolveFields(ctx context.Context, v interface{}) error {
+	return c.resolveReflect(ctx, reflect.ValueOf(v), "")
+}
+
+func (c *Config) resolveReflect(ctx context.Context, v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface && v.Elem().Kind() == reflect.String {
+			resolved, err := c.resolveString(ctx, v.Elem().String(), path)
+			if err != nil {
+				return err
+			}
+			if v.CanSet() {
+				v.Set(reflect.ValueOf(resolved))
+			}
+			return nil
+		}
+		return c.resolveReflect(ctx, v.Elem(), path)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if err := c.resolveReflect(ctx, v.Field(i), joinFieldPath(path, t.Field(i).Name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := c.resolveReflect(ctx, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// map values aren't addressable, so resolve a copy and store it back
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := c.resolveReflect(ctx, elem, fmt.Sprintf("%s[%v]", path, iter.Key())); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	case reflect.String:
+		resolved, err := c.resolveString(ctx, v.String(), path)
+		if err != nil {
+			return err
+		}
+		if v.CanSet() {
+			v.SetString(resolved)
+		}
+	}
+	return nil
+}
+
+func (c *Config) resolveString(ctx context.Context, s, path string) (string, error) {
+	resolved, err := c.resolveValue(ctx, s)
+	if err != nil && path != "" {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return resolved, err
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// splitReference splits a value into a registered scheme and a reference.
+// References are a single line, so multi-line values such as YAML documents
+// are never mistaken for one.
+func splitReference(v string) (string, string, bool) {
+	v = strings.TrimRight(v, "\r\n")
+	i := strings.Index(v, ":")
+	if i < 0 || strings.ContainsAny(v, "\r\n") {
+		return "", "", false
+	}
+	if _, ok := resolvers[v[:i]]; !ok {
+		return "", "", false
+	}
+	return v[:i], v[i+1:], true
+}
+
+// resolveEnv resolves env:NAME to the value of an environment variable
+func resolveEnv(_ context.Context, _ *Config, name string) (string, error) {
+	val, isset := os.LookupEnv(name)
+	if !isset {
+		return "", fmt.Errorf("expected environment variable, %s, is not set", name)
+	}
+	return val, nil
+}
+
+// resolveFile resolves file:/path to the contents of a file, without any
+// trailing newline. Relative paths are relative to the config root, or in
+// the config's fs.FS if it isn't on disk.
+func resolveFile(_ context.Context, c *Config, path string) (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case filepath.IsAbs(path):
+		data, err = ioutil.ReadFile(path)
+	case c.root != "":
+		data, err = ioutil.ReadFile(filepath.Join(c.root, path))
+	default:
+		data, err = fs.ReadFile(c.fsys, filepath.ToSlash(path))
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveBase64 resolves base64:... to the decoded value
+func resolveBase64(_ context.Context, _ *Config, encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// resolveExec resolves exec:helper args... by running a credential helper,
+// which must print a JSON object with a "secret" field to stdout. The helper
+// is killed if ctx is done before it exits.
+func resolveExec(ctx context.Context, _ *Config, command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	var output struct {
+		Secret *string `json:"secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return "", fmt.Errorf("%s did not print a JSON object: %w", args[0], err)
+	}
+	if output.Secret == nil {
+		return "", fmt.Errorf("%s did not print a secret", args[0])
+	}
+	return *output.Secret, nil
+}
@@ -0,0 +1,178 @@
+package configpod
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pachyderm/pachyderm/v2/src/license"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveValue(t *testing.T) {
+	root, err := ioutil.TempDir("", "resolve")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	config := NewConfig(root)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "secret"), []byte("from-file\n"), 0644))
+	require.NoError(t, os.Setenv("RESOLVE_TEST_SECRET", "from-env"))
+	defer os.Unsetenv("RESOLVE_TEST_SECRET")
+
+	for value, expected := range map[string]string{
+		"plain":                                 "plain",
+		"http://pachd:1658/":                    "http://pachd:1658/",
+		"robot:test":                            "robot:test",
+		"$RESOLVE_TEST_SECRET":                  "from-env",
+		"env:RESOLVE_TEST_SECRET":               "from-env",
+		"file:secret":                           "from-file",
+		"file:" + filepath.Join(root, "secret"): "from-file",
+		"base64:ZnJvbS1iYXNlNjQ=":               "from-base64",
+		`exec:echo {"secret":"from-exec"}`:      "from-exec",
+		"env:multi\nline":                       "env:multi\nline",
+	} {
+		resolved, err := config.resolveValue(context.Background(), value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, resolved, value)
+	}
+
+	for _, value := range []string{
+		"$RESOLVE_TEST_UNSET",
+		"env:RESOLVE_TEST_UNSET",
+		"file:missing",
+		"base64:!!!",
+		"exec:false",
+		`exec:echo {"other":"value"}`,
+	} {
+		_, err := config.resolveValue(context.Background(), value)
+		require.Error(t, err, value)
+	}
+}
+
+// TestResolveExecTimeout tests that a credential helper is killed when the
+// step it's resolved for times out
+func TestResolveExecTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := NewConfig("").resolveValue(ctx, "exec:sleep 10")
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestResolveFields(t *testing.T) {
+	config := NewConfig("")
+	require.NoError(t, os.Setenv("RESOLVE_TEST_SECRET", "from-env"))
+	defer os.Unsetenv("RESOLVE_TEST_SECRET")
+
+	type nested struct {
+		Secret  string
+		Secrets map[string]string
+		Any     interface{}
+	}
+	clusters := []license.AddClusterRequest{{
+		Id:                  "localhost",
+		Address:             "grpc://localhost:1653",
+		Secret:              "env:RESOLVE_TEST_SECRET",
+		ClusterDeploymentId: "$RESOLVE_TEST_SECRET",
+	}}
+	v := struct {
+		Clusters []license.AddClusterRequest
+		Nested   *nested
+		Values   map[string]*nested
+		secret   string
+	}{
+		Clusters: clusters,
+		Nested:   &nested{Secret: "$RESOLVE_TEST_SECRET", Secrets: map[string]string{"a": "base64:ZnJvbS1iYXNlNjQ="}},
+		Values:   map[string]*nested{"b": {Any: "$RESOLVE_TEST_SECRET"}},
+		secret:   "$RESOLVE_TEST_SECRET",
+	}
+	require.NoError(t, config.resolveFields(context.Background(), &v))
+
+	require.Equal(t, "grpc://localhost:1653", v.Clusters[0].Address)
+	require.Equal(t, "from-env", v.Clusters[0].Secret)
+	require.Equal(t, "from-env", v.Clusters[0].ClusterDeploymentId)
+	require.Equal(t, "from-env", v.Nested.Secret)
+	require.Equal(t, "from-base64", v.Nested.Secrets["a"])
+	require.Equal(t, "from-env", v.Values["b"].Any)
+	require.Equal(t, "$RESOLVE_TEST_SECRET", v.secret)
+
+	v.Nested.Secret = "$RESOLVE_TEST_UNSET"
+	err := config.resolveFields(context.Background(), &v)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Nested.Secret")
+}
+
+// TestLoadYAMLWholeFileReference tests that a config file whose whole content
+// is a secret reference is resolved to the YAML document it references
+func TestLoadYAMLWholeFileReference(t *testing.T) {
+	root, err := ioutil.TempDir("", "resolve")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	config := NewConfig(root)
+
+	require.NoError(t, os.Setenv("RESOLVE_TEST_BINDINGS", "robot:test: [repoReader]\n"))
+	defer os.Unsetenv("RESOLVE_TEST_BINDINGS")
+	require.NoError(t, os.Setenv("RESOLVE_TEST_SECRET", "from-env"))
+	defer os.Unsetenv("RESOLVE_TEST_SECRET")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, clusterRoleBindingsPath), []byte("$RESOLVE_TEST_BINDINGS\n"), 0644))
+	var bindings map[string][]string
+	require.NoError(t, config.loadYAML(context.Background(), clusterRoleBindingsPath, &bindings))
+	require.Equal(t, map[string][]string{"robot:test": {"repoReader"}}, bindings)
+
+	// the referenced document's own references are resolved too
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "clusters.yaml"), []byte("- id: localhost\n  secret: env:RESOLVE_TEST_SECRET\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, enterpriseClustersPath), []byte("file:clusters.yaml"), 0644))
+	var clusters []license.AddClusterRequest
+	require.NoError(t, config.loadYAML(context.Background(), enterpriseClustersPath, &clusters))
+	require.Len(t, clusters, 1)
+	require.Equal(t, "localhost", clusters[0].Id)
+	require.Equal(t, "from-env", clusters[0].Secret)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, clusterRoleBindingsPath), []byte("$RESOLVE_TEST_UNSET"), 0644))
+	require.Error(t, config.loadYAML(context.Background(), clusterRoleBindingsPath, &bindings))
+
+	// Validate checks the reference, rather than decoding it as YAML
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, clusterRoleBindingsPath), []byte("$RESOLVE_TEST_BINDINGS\n"), 0644))
+	require.Empty(t, config.Validate())
+}
+
+// TestNewConfigFS tests loading a config which isn't on disk
+func TestNewConfigFS(t *testing.T) {
+	config := NewConfigFS(fstest.MapFS{
+		rootTokenPath:                   {Data: []byte("file:secrets/rootToken\n")},
+		"secrets/rootToken":             {Data: []byte("fsroottoken\n")},
+		clusterRoleBindingsPath:         {Data: []byte("robot:test: [repoReader]\n")},
+		pipelinesPath + "/edges.yaml":   {Data: []byte("pipeline:\n  name: edges\n")},
+		pipelinesPath + "/montage.json": {Data: []byte(`{"pipeline": {"name": "montage"}}`)},
+		pipelinesPath + "/README.md":    {Data: []byte("not a spec")},
+	})
+	require.Equal(t, "", config.Root())
+
+	token, err := config.RootToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "fsroottoken", token)
+
+	var bindings map[string][]string
+	require.NoError(t, config.loadYAML(context.Background(), clusterRoleBindingsPath, &bindings))
+	require.Equal(t, map[string][]string{"robot:test": {"repoReader"}}, bindings)
+
+	specs, err := config.loadPipelineSpecs()
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	require.Equal(t, "edges", specs[0].request.Pipeline.Name)
+	require.Equal(t, "montage", specs[1].request.Pipeline.Name)
+
+	err = config.loadYAML(context.Background(), oidcClientsPath, &bindings)
+	require.True(t, errors.Is(err, ErrSkipped))
+	require.Contains(t, err.Error(), "no file "+oidcClientsPath)
+
+	require.Empty(t, config.Validate())
+}
@@ -0,0 +1,110 @@
+package configpod
+
+import (
+	"errors"
+	"time"
+)
+
+// StepOutcome is the result of a single step
+type StepOutcome string
+
+const (
+	OutcomeApplied StepOutcome = "applied"
+	OutcomePlanned StepOutcome = "planned"
+	OutcomeSkipped StepOutcome = "skipped"
+	OutcomeFailed  StepOutcome = "failed"
+	// OutcomeNotRun is the outcome of the steps which depend on a failed step
+	OutcomeNotRun StepOutcome = "not run"
+)
+
+// unverifiableKinds are the kinds of items which can't be read back from
+// pachd, so an update is always planned for them even when nothing has changed
+var unverifiableKinds = map[string]bool{
+	kindEnterpriseConfig: true,
+}
+
+// ItemResult is what a step did, or would do in a dry run, to a single item
+// of cluster state
+type ItemResult struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Action Action `json:"action"`
+	// Applied is true if the change was made, which it isn't for unchanged
+	// items, in dry runs, or if making it failed
+	Applied bool `json:"applied"`
+}
+
+// Drifted returns true if the item's planned change means the cluster
+// differs from the config
+func (i ItemResult) Drifted() bool {
+	if i.Action == ActionUnchanged {
+		return false
+	}
+	return i.Action != ActionUpdate || !unverifiableKinds[i.Kind]
+}
+
+// StepResult is the result of running a step against a target, or of the
+// only run of an enterprise-scoped step
+type StepResult struct {
+	Name string `json:"name"`
+	// Target is the name of the target a cluster-scoped step was applied to,
+	// when the targets key is set
+	Target   string       `json:"target,omitempty"`
+	Outcome  StepOutcome  `json:"outcome"`
+	Reason   string       `json:"reason,omitempty"`
+	Duration float64      `json:"durationSeconds"`
+	Items    []ItemResult `json:"items,omitempty"`
+}
+
+// Result records what a run of the sync steps did
+type Result struct {
+	// Outcome is "succeeded" or "failed"
+	Outcome   string       `json:"outcome"`
+	DryRun    bool         `json:"dryRun"`
+	StartTime time.Time    `json:"startTime"`
+	Duration  float64      `json:"durationSeconds"`
+	Steps     []StepResult `json:"steps"`
+}
+
+func newResult(dryRun bool) *Result {
+	return &Result{DryRun: dryRun, StartTime: time.Now()}
+}
+
+// addStep records the result of running a step
+func (r *Result) addStep(name string, st *stepState, err error, duration time.Duration) {
+	step := StepResult{Name: name, Target: st.target.name(), Duration: duration.Seconds()}
+	switch {
+	case err == nil && r.DryRun:
+		step.Outcome = OutcomePlanned
+	case err == nil:
+		step.Outcome = OutcomeApplied
+	case errors.Is(err, ErrSkipped):
+		step.Outcome = OutcomeSkipped
+		step.Reason = err.Error()
+	default:
+		step.Outcome = OutcomeFailed
+		step.Reason = err.Error()
+	}
+
+	completed := make(map[change]bool)
+	for _, c := range st.completed {
+		completed[c] = true
+	}
+	for _, c := range st.changes {
+		step.Items = append(step.Items, ItemResult{Kind: c.Kind, ID: c.ID, Action: c.Action, Applied: completed[c]})
+	}
+	r.Steps = append(r.Steps, step)
+}
+
+// addNotRun records a step which wasn't run because a step it depends on failed
+func (r *Result) addNotRun(name string, t *Target, reason string) {
+	r.Steps = append(r.Steps, StepResult{Name: name, Target: t.name(), Outcome: OutcomeNotRun, Reason: reason})
+}
+
+func (r *Result) finish(err error) {
+	r.Duration = time.Since(r.StartTime).Seconds()
+	r.Outcome = "succeeded"
+	if err != nil {
+		r.Outcome = "failed"
+	}
+}
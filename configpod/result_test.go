@@ -0,0 +1,46 @@
+package configpod
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResult(t *testing.T) {
+	r := newResult(false)
+	r.addStep("license key", &stepState{}, fmt.Errorf("%w - no file license", ErrSkipped), time.Second)
+
+	images := change{Kind: kindRepo, ID: "images", Action: ActionCreate}
+	edges := change{Kind: kindRepo, ID: "edges", Action: ActionUnchanged}
+	montage := change{Kind: kindRepo, ID: "montage", Action: ActionUpdate}
+	r.addStep("sync repos", &stepState{
+		changes:   []change{images, edges, montage},
+		completed: []change{images},
+	}, errors.New("repo montage not found"), 2*time.Second)
+
+	r.addNotRun("sync pipelines", &Target{Name: "east"}, `depends on "sync repos [east]", which failed`)
+	r.finish(errors.New("repo montage not found"))
+
+	require.Equal(t, "failed", r.Outcome)
+	require.Equal(t, []StepResult{
+		{Name: "license key", Outcome: OutcomeSkipped, Reason: "skipped step - no file license", Duration: 1},
+		{Name: "sync repos", Outcome: OutcomeFailed, Reason: "repo montage not found", Duration: 2, Items: []ItemResult{
+			{Kind: kindRepo, ID: "images", Action: ActionCreate, Applied: true},
+			{Kind: kindRepo, ID: "edges", Action: ActionUnchanged},
+			{Kind: kindRepo, ID: "montage", Action: ActionUpdate},
+		}},
+		{Name: "sync pipelines", Target: "east", Outcome: OutcomeNotRun, Reason: `depends on "sync repos [east]", which failed`},
+	}, r.Steps)
+}
+
+func TestDrifted(t *testing.T) {
+	require.False(t, ItemResult{Kind: kindRepo, Action: ActionUnchanged}.Drifted())
+	require.True(t, ItemResult{Kind: kindRepo, Action: ActionUpdate}.Drifted())
+	require.True(t, ItemResult{Kind: kindIDPConnector, Action: ActionDelete}.Drifted())
+	// the enterprise config can't be compared, so an update isn't drift
+	require.False(t, ItemResult{Kind: kindEnterpriseConfig, Action: ActionUpdate}.Drifted())
+	require.True(t, ItemResult{Kind: kindEnterpriseConfig, Action: ActionCreate}.Drifted())
+}
@@ -0,0 +1,270 @@
+package configpod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := checkStepGraph(syncSteps); err != nil {
+		panic(err)
+	}
+}
+
+// checkStepGraph checks that every dependency of a step exists, and that
+// no step depends on itself, even indirectly, which would never run
+func checkStepGraph(steps []syncStep) error {
+	deps := make(map[string][]string)
+	for _, step := range steps {
+		if _, ok := deps[step.name]; ok {
+			return fmt.Errorf("duplicate step %q", step.name)
+		}
+		deps[step.name] = step.deps
+	}
+
+	// visiting is the path of steps being checked, and checked the steps
+	// whose dependencies are known not to loop
+	visiting := make(map[string]bool)
+	checked := make(map[string]bool)
+	var visit func(name string) error
+	visit = func(name string) error {
+		if checked[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("step %q depends on itself", name)
+		}
+		visiting[name] = true
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		checked[name] = true
+		return nil
+	}
+	for _, step := range steps {
+		if err := visit(step.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepRun is a run of a step against a target, or the only run of an
+// enterprise-scoped step, whose target is nil
+type stepRun struct {
+	step   syncStep
+	target *Target
+	deps   []*stepRun
+	// after are every target's run of a dependency of an enterprise-scoped
+	// step, which stop it only if they all fail
+	after [][]*stepRun
+	// done is closed once the step has finished, or won't be run
+	done chan struct{}
+
+	st       *stepState
+	err      error
+	duration time.Duration
+	// notRun is why the step wasn't run, if one of its dependencies failed
+	notRun string
+
+	// logs and plan buffer the step's output, so that it's written in the
+	// order of syncSteps rather than the order the steps finish in
+	logs bytes.Buffer
+	plan bytes.Buffer
+}
+
+// failed returns true if the step failed or wasn't run, so its dependents can't run
+func (r *stepRun) failed() bool {
+	return r.notRun != "" || (r.err != nil && !errors.Is(r.err, ErrSkipped))
+}
+
+// planRuns returns a run of each step for every target it applies to, in
+// the order of syncSteps. A cluster-scoped step depends on the same target's
+// run of a cluster-scoped dependency. An enterprise-scoped step waits for
+// every target's run of one, but is only stopped if it failed on every
+// target, so that one broken target doesn't stop the steps of the others.
+func planRuns(steps []syncStep, targets []*Target) []*stepRun {
+	var runs []*stepRun
+	byStep := make(map[string][]*stepRun)
+	for _, step := range steps {
+		stepTargets := targets
+		if step.scope == enterpriseScope {
+			stepTargets = []*Target{nil}
+		}
+		for _, t := range stepTargets {
+			r := &stepRun{step: step, target: t, done: make(chan struct{})}
+			runs = append(runs, r)
+			byStep[step.name] = append(byStep[step.name], r)
+		}
+	}
+
+	for _, r := range runs {
+		for _, name := range r.step.deps {
+			if r.target == nil && len(byStep[name]) > 0 && byStep[name][0].target != nil {
+				r.after = append(r.after, byStep[name])
+				continue
+			}
+			for _, dep := range byStep[name] {
+				if r.target != nil && dep.target != nil && dep.target != r.target {
+					continue
+				}
+				r.deps = append(r.deps, dep)
+			}
+		}
+	}
+	return runs
+}
+
+// failedOnEvery returns true if every run failed or wasn't run
+func failedOnEvery(runs []*stepRun) bool {
+	for _, r := range runs {
+		if !r.failed() {
+			return false
+		}
+	}
+	return true
+}
+
+// bufferedLogger returns a logger like logger, which writes to w
+func bufferedLogger(logger *log.Logger, w io.Writer) *log.Logger {
+	return &log.Logger{
+		Out:       w,
+		Hooks:     logger.Hooks,
+		Formatter: logger.Formatter,
+		Level:     logger.GetLevel(),
+		ExitFunc:  logger.ExitFunc,
+	}
+}
+
+// run waits for the step's dependencies, then runs it unless one of them failed
+func (r *stepRun) run(ctx context.Context, s *Syncer, config *Config) {
+	defer close(r.done)
+
+	logger := log.NewEntry(bufferedLogger(s.logger.Logger, &r.logs)).WithFields(s.logger.Data).WithField("step", r.step.name)
+	if r.target.name() != "" {
+		logger = logger.WithField("target", r.target.Name)
+	}
+
+	deps := r.deps
+	for _, runs := range r.after {
+		for _, dep := range runs {
+			<-dep.done
+		}
+		// a dependency which succeeded on any target is as good as a success,
+		// otherwise the first target's failure stops the step
+		if failedOnEvery(runs) {
+			deps = append(deps, runs[0])
+		}
+	}
+	for _, dep := range r.deps {
+		<-dep.done
+	}
+	for _, dep := range deps {
+		if dep.failed() {
+			r.notRun = fmt.Sprintf("depends on %q, which failed", dep.target.label(dep.step.name))
+			if dep.notRun != "" {
+				r.notRun = fmt.Sprintf("depends on %q, which wasn't run", dep.target.label(dep.step.name))
+			}
+			logger.WithField("reason", r.notRun).Warn("not run")
+			return
+		}
+	}
+
+	r.st = &stepState{dryRun: s.dryRun, config: config, target: r.target, logger: logger}
+	if s.excluded[r.step.name] {
+		r.err = fmt.Errorf("%w - excluded by --only or --skip", ErrSkipped)
+		if s.dryRun {
+			printPlan(&r.plan, r.target.label(r.step.name), nil, r.err)
+		}
+		logger.WithField("reason", r.err).Info("skipped")
+		return
+	}
+
+	c := s.ec
+	if r.target != nil {
+		c = r.target.Client
+	}
+
+	logger.Info("running step")
+	start := time.Now()
+	stepCtx, cancel := withTimeout(ctx, s.stepTimeout)
+	defer cancel()
+	err := r.step.fn(stepCtx, c.WithCtx(stepCtx), s.ec.WithCtx(stepCtx), r.st)
+	if err != nil && stepCtx.Err() != nil {
+		// a cancelled RPC's error doesn't say whether it timed out or was signalled
+		err = fmt.Errorf("step interrupted: %w", stepCtx.Err())
+	}
+	r.err = err
+	r.duration = time.Since(start)
+
+	if s.dryRun {
+		printPlan(&r.plan, r.target.label(r.step.name), r.st.changes, err)
+	}
+	switch {
+	case err == nil:
+		logger.Info("success")
+	case errors.Is(err, ErrSkipped):
+		logger.WithField("reason", err).Warn("skipped")
+	default:
+		logger.WithError(err).WithField("completed", describeChanges(r.st.completed)).Error("error syncing cluster state")
+	}
+}
+
+// Run runs the sync steps, each as soon as its dependencies have succeeded
+// or been skipped. Enterprise-scoped steps run once against the enterprise
+// server, and cluster-scoped steps against each target. A step that fails,
+// or is interrupted by ctx or a timeout, stops the steps which depend on it
+// on the same target, and the enterprise-scoped steps which depend on it if
+// it failed on every target. The steps' logs and plans are written, and the
+// result records every step, including the ones that weren't run, in the
+// order of syncSteps. The result is returned even if a step failed, along
+// with the first failure.
+func (s *Syncer) Run(ctx context.Context) (*Result, error) {
+	ctx, cancel := withTimeout(ctx, s.syncTimeout)
+	defer cancel()
+
+	config := s.config.forRun()
+	runs := planRuns(s.steps, s.targets)
+	for _, r := range runs {
+		go r.run(ctx, s, config)
+	}
+
+	result := newResult(s.dryRun)
+	var planned []change
+	var runErr error
+	for _, r := range runs {
+		<-r.done
+		s.logger.Logger.Out.Write(r.logs.Bytes())
+		if r.notRun != "" {
+			result.addNotRun(r.step.name, r.target, r.notRun)
+			continue
+		}
+
+		result.addStep(r.step.name, r.st, r.err, r.duration)
+		if s.dryRun {
+			s.planOutput.Write(r.plan.Bytes())
+			planned = append(planned, r.st.changes...)
+		}
+		if r.failed() && runErr == nil {
+			runErr = r.err
+		}
+	}
+
+	result.finish(runErr)
+	if s.dryRun && runErr == nil {
+		printPlanSummary(s.planOutput, planned)
+	}
+	return result, runErr
+}
@@ -0,0 +1,122 @@
+package configpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/v2/src/client"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStepGraph(t *testing.T) {
+	require.NoError(t, checkStepGraph(syncSteps))
+
+	require.EqualError(t, checkStepGraph([]syncStep{
+		{name: "a", deps: []string{"c"}},
+		{name: "b", deps: []string{"a"}},
+		{name: "c", deps: []string{"b"}},
+	}), `step "a" depends on itself`)
+	require.EqualError(t, checkStepGraph([]syncStep{
+		{name: "a", deps: []string{"b"}},
+	}), `step "a" depends on unknown step "b"`)
+}
+
+// TestRunConcurrently tests that independent steps run at the same time,
+// that the dependents of a failed step aren't run, and that the result lists
+// the steps in order whatever order they finish in
+func TestRunConcurrently(t *testing.T) {
+	// a and b each wait for the other to start, so they only finish if
+	// they're run concurrently
+	aStarted, bStarted := make(chan struct{}), make(chan struct{})
+	wait := func(started, other chan struct{}) clusterSyncFn {
+		return func(ctx context.Context, _, _ *client.APIClient, _ *stepState) error {
+			close(started)
+			select {
+			case <-other:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	skip := func(context.Context, *client.APIClient, *client.APIClient, *stepState) error {
+		return fmt.Errorf("%w - no file", ErrSkipped)
+	}
+	fail := func(context.Context, *client.APIClient, *client.APIClient, *stepState) error {
+		return errors.New("failed")
+	}
+	targets := []*Target{{Name: "east", Client: &client.APIClient{}}, {Name: "west", Client: &client.APIClient{}}}
+	syncer, err := NewSyncer(WithConfig(NewConfig("")), WithTargets(targets...), WithEnterpriseClient(&client.APIClient{}))
+	require.NoError(t, err)
+	syncer.steps = []syncStep{
+		{"a", wait(aStarted, bStarted), enterpriseScope, nil},
+		{"b", wait(bStarted, aStarted), enterpriseScope, nil},
+		{"skipped", skip, clusterScope, []string{"a"}},
+		{"after skipped", skip, clusterScope, []string{"skipped"}},
+		{"failed", fail, clusterScope, []string{"b"}},
+		{"after failed", skip, enterpriseScope, []string{"failed", "a"}},
+		{"after not run", skip, clusterScope, []string{"after failed"}},
+	}
+
+	report, err := syncer.Run(context.Background())
+	require.EqualError(t, err, "failed")
+
+	var outcomes []string
+	for _, step := range report.Steps {
+		outcomes = append(outcomes, fmt.Sprintf("%s [%s]: %s %s", step.Name, step.Target, step.Outcome, step.Reason))
+	}
+	require.Equal(t, []string{
+		"a []: applied ",
+		"b []: applied ",
+		"skipped [east]: skipped skipped step - no file",
+		"skipped [west]: skipped skipped step - no file",
+		"after skipped [east]: skipped skipped step - no file",
+		"after skipped [west]: skipped skipped step - no file",
+		"failed [east]: failed failed",
+		"failed [west]: failed failed",
+		`after failed []: not run depends on "failed [east]", which failed`,
+		`after not run [east]: not run depends on "after failed", which wasn't run`,
+		`after not run [west]: not run depends on "after failed", which wasn't run`,
+	}, outcomes)
+}
+
+// TestRunOneTargetFails tests that a step which fails on one target only
+// stops that target's dependent steps, not the enterprise-scoped steps or the
+// other targets' steps which depend on them
+func TestRunOneTargetFails(t *testing.T) {
+	ok := func(context.Context, *client.APIClient, *client.APIClient, *stepState) error {
+		return nil
+	}
+	failOnEast := func(_ context.Context, _, _ *client.APIClient, st *stepState) error {
+		if st.target.Name == "east" {
+			return errors.New("east is broken")
+		}
+		return nil
+	}
+	targets := []*Target{{Name: "east", Client: &client.APIClient{}}, {Name: "west", Client: &client.APIClient{}}}
+	syncer, err := NewSyncer(WithConfig(NewConfig("")), WithTargets(targets...), WithEnterpriseClient(&client.APIClient{}))
+	require.NoError(t, err)
+	syncer.steps = []syncStep{
+		{"identity", failOnEast, clusterScope, nil},
+		{"clients", ok, enterpriseScope, []string{"identity"}},
+		{"auth", ok, clusterScope, []string{"clients", "identity"}},
+	}
+
+	report, err := syncer.Run(context.Background())
+	require.EqualError(t, err, "east is broken")
+
+	var outcomes []string
+	for _, step := range report.Steps {
+		outcomes = append(outcomes, fmt.Sprintf("%s [%s]: %s %s", step.Name, step.Target, step.Outcome, step.Reason))
+	}
+	require.Equal(t, []string{
+		"identity [east]: failed east is broken",
+		"identity [west]: applied ",
+		"clients []: applied ",
+		`auth [east]: not run depends on "identity [east]", which failed`,
+		"auth [west]: applied ",
+	}, outcomes)
+}
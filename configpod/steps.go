@@ -0,0 +1,839 @@
+package configpod
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+	"github.com/pachyderm/pachyderm/v2/src/client"
+	"github.com/pachyderm/pachyderm/v2/src/enterprise"
+	"github.com/pachyderm/pachyderm/v2/src/identity"
+	"github.com/pachyderm/pachyderm/v2/src/license"
+	"github.com/pachyderm/pachyderm/v2/src/pfs"
+	"github.com/pachyderm/pachyderm/v2/src/pps"
+
+	"github.com/ghodss/yaml"
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	localhostEnterpriseClusterId = "localhost"
+)
+
+// pruneConfig makes steps authoritative for the items they manage: items that
+// exist in the cluster but aren't declared in the config are deleted
+type pruneConfig struct {
+	// OIDCClients deletes OIDC clients that aren't listed in oidcClients
+	OIDCClients bool `json:"oidcClients"`
+	// KeepOIDCClients are never pruned, e.g. clients pachd creates itself
+	KeepOIDCClients []string `json:"keepOIDCClients"`
+	// IDPs deletes identity provider connectors that aren't listed in idps
+	IDPs bool `json:"idps"`
+	// KeepIDPs are never pruned
+	KeepIDPs []string `json:"keepIDPs"`
+	// EnterpriseClusters deletes license server clusters that aren't listed
+	// in enterpriseClusters. The embedded "localhost" cluster is always kept.
+	EnterpriseClusters bool `json:"enterpriseClusters"`
+	// KeepEnterpriseClusters are never pruned
+	KeepEnterpriseClusters []string `json:"keepEnterpriseClusters"`
+}
+
+// loadPruneConfig loads the prune config, which defaults to pruning nothing
+func (c *Config) loadPruneConfig(ctx context.Context) (pruneConfig, error) {
+	var config pruneConfig
+	if err := c.loadYAML(ctx, prunePath, &config); err != nil && !errors.Is(err, ErrSkipped) {
+		return config, err
+	}
+	return config, nil
+}
+
+// undeclaredAction returns the action for an item with the given id which
+// is in the cluster but not the config: it's deleted if pruning is enabled
+// for its kind, and otherwise reported as undeclared. Items in keep are
+// expected to be there, so ok is false for them and they aren't reported.
+func undeclaredAction(enabled bool, keep []string, id string) (action Action, ok bool) {
+	for _, k := range keep {
+		if k == id {
+			return "", false
+		}
+	}
+	if !enabled {
+		return ActionUndeclared, true
+	}
+	return ActionDelete, true
+}
+
+func localhostEnterpriseCluster(secret string) license.AddClusterRequest {
+	return license.AddClusterRequest{
+		Id:               localhostEnterpriseClusterId,
+		Address:          "grpc://localhost:1653",
+		UserAddress:      "grpc://localhost:1653",
+		Secret:           secret,
+		EnterpriseServer: true,
+	}
+}
+
+func localhostEnterpriseConfig(secret string) enterprise.ActivateRequest {
+	return enterprise.ActivateRequest{
+		Id:            localhostEnterpriseClusterId,
+		LicenseServer: "grpc://localhost:1653",
+		Secret:        secret,
+	}
+}
+
+// isErrNotActivated returns true if a read failed because the service
+// hasn't been activated yet, which in dry-run mode means nothing exists
+func isErrNotActivated(err error) bool {
+	return auth.IsErrNotActivated(err) || license.IsErrNotActivated(err)
+}
+
+func licenseStep(ctx context.Context, _ *client.APIClient, ec *client.APIClient, st *stepState) error {
+	key, err := st.loadResolvable(ctx, licensePath)
+	if err != nil {
+		return err
+	}
+
+	current, err := ec.License.GetActivationCode(ec.Ctx(), &license.GetActivationCodeRequest{})
+	if err != nil {
+		return err
+	}
+
+	action := diffAction(current.State != enterprise.State_NONE, current.ActivationCode == string(key))
+	return st.apply(ctx, kindLicense, "activation code", action, func() error {
+		_, err := ec.License.Activate(ec.Ctx(), &license.ActivateRequest{
+			ActivationCode: string(key),
+		})
+		return err
+	})
+}
+
+// enterpriseState returns the enterprise state of a pachd, or NONE if
+// the state can't be read because auth isn't active yet
+func enterpriseState(c *client.APIClient) (enterprise.State, error) {
+	state, err := c.Enterprise.GetState(c.Ctx(), &enterprise.GetStateRequest{})
+	if err != nil {
+		if isErrNotActivated(err) {
+			return enterprise.State_NONE, nil
+		}
+		return enterprise.State_NONE, err
+	}
+	return state.State, nil
+}
+
+// The enterprise config can't be read back from pachd, so an active
+// config is always re-applied
+func enterpriseConfigAction(c *client.APIClient) (Action, error) {
+	state, err := enterpriseState(c)
+	if err != nil {
+		return "", err
+	}
+	return diffAction(state != enterprise.State_NONE, false), nil
+}
+
+// listEnterpriseClusters returns the clusters registered with the license
+// server, with the user address and deployment id filled in
+func listEnterpriseClusters(ec *client.APIClient) (map[string]*license.UpdateClusterRequest, error) {
+	result := make(map[string]*license.UpdateClusterRequest)
+	clusters, err := ec.License.ListClusters(ec.Ctx(), &license.ListClustersRequest{})
+	if err != nil {
+		if isErrNotActivated(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	for _, cluster := range clusters.Clusters {
+		result[cluster.Id] = &license.UpdateClusterRequest{
+			Id:      cluster.Id,
+			Address: cluster.Address,
+		}
+	}
+
+	userClusters, err := ec.License.ListUserClusters(ec.Ctx(), &license.ListUserClustersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range userClusters.Clusters {
+		if r, ok := result[cluster.Id]; ok {
+			r.UserAddress = cluster.Address
+			r.ClusterDeploymentId = cluster.ClusterDeploymentId
+		}
+	}
+	return result, nil
+}
+
+func enterpriseSecretStep(ctx context.Context, _ *client.APIClient, ec *client.APIClient, st *stepState) error {
+	secret, err := st.loadResolvable(ctx, enterpriseSecretPath)
+	if err != nil {
+		return err
+	}
+
+	existing, err := listEnterpriseClusters(ec)
+	if err != nil {
+		return err
+	}
+
+	cluster := localhostEnterpriseCluster(string(secret))
+	_, exists := existing[cluster.Id]
+	if err := st.apply(ctx, kindEnterpriseCluster, cluster.Id, diffAction(exists, true), func() error {
+		if _, err := ec.License.AddCluster(ec.Ctx(), &cluster); err != nil {
+			if !license.IsErrDuplicateClusterID(err) {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	action, err := enterpriseConfigAction(ec)
+	if err != nil {
+		return err
+	}
+
+	config := localhostEnterpriseConfig(string(secret))
+	return st.apply(ctx, kindEnterpriseConfig, config.Id, action, func() error {
+		_, err := ec.Enterprise.Activate(ec.Ctx(), &config)
+		return err
+	})
+}
+
+func syncEnterpriseClusters(ctx context.Context, ec *client.APIClient, clusters []license.AddClusterRequest, prune pruneConfig, st *stepState) error {
+	existing, err := listEnterpriseClusters(ec)
+	if err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		ex, exists := existing[cluster.Id]
+		action := diffAction(exists, exists &&
+			ex.Address == cluster.Address &&
+			ex.UserAddress == cluster.UserAddress &&
+			ex.ClusterDeploymentId == cluster.ClusterDeploymentId)
+
+		update := &license.UpdateClusterRequest{
+			Id:                  cluster.Id,
+			Address:             cluster.Address,
+			UserAddress:         cluster.UserAddress,
+			ClusterDeploymentId: cluster.ClusterDeploymentId,
+		}
+		cluster := cluster
+		if err := st.apply(ctx, kindEnterpriseCluster, cluster.Id, action, func() error {
+			if action == ActionCreate {
+				_, err := ec.License.AddCluster(ec.Ctx(), &cluster)
+				if !license.IsErrDuplicateClusterID(err) {
+					return err
+				}
+			}
+			_, err := ec.License.UpdateCluster(ec.Ctx(), update)
+			return err
+		}); err != nil {
+			return err
+		}
+		delete(existing, cluster.Id)
+	}
+
+	// anything left in existing wasn't declared
+	var undeclared []string
+	for id := range existing {
+		// the embedded enterprise server registered by enterpriseSecretStep
+		// is never pruned, or reported
+		if id != localhostEnterpriseClusterId {
+			undeclared = append(undeclared, id)
+		}
+	}
+	sort.Strings(undeclared)
+
+	for _, id := range undeclared {
+		action, ok := undeclaredAction(prune.EnterpriseClusters, prune.KeepEnterpriseClusters, id)
+		if !ok {
+			continue
+		}
+		id := id
+		if err := st.apply(ctx, kindEnterpriseCluster, id, action, func() error {
+			st.log().WithField("cluster", id).Info("deleting undeclared enterprise cluster")
+			_, err := ec.License.DeleteCluster(ec.Ctx(), &license.DeleteClusterRequest{Id: id})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func enterpriseClustersStep(ctx context.Context, _ *client.APIClient, ec *client.APIClient, st *stepState) error {
+	var clusters []license.AddClusterRequest
+	if err := st.loadYAML(ctx, enterpriseClustersPath, &clusters); err != nil {
+		return err
+	}
+
+	prune, err := st.config.loadPruneConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	return syncEnterpriseClusters(ctx, ec, clusters, prune, st)
+}
+
+// sortedClientIDs returns the ids of the clients in order, so deletions are deterministic
+func sortedClientIDs(m map[string]*identity.OIDCClient) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func syncOIDCClients(ctx context.Context, ec *client.APIClient, clients []identity.OIDCClient, prune pruneConfig, st *stepState) error {
+	existing := make(map[string]*identity.OIDCClient)
+	resp, err := ec.ListOIDCClients(ec.Ctx(), &identity.ListOIDCClientsRequest{})
+	if err != nil && !isErrNotActivated(err) {
+		return err
+	}
+	if err == nil {
+		for _, ex := range resp.Clients {
+			existing[ex.Id] = ex
+		}
+	}
+
+	for _, client := range clients {
+		ex, exists := existing[client.Id]
+		action := diffAction(exists, exists && proto.Equal(ex, &client))
+		client := client
+		if err := st.apply(ctx, kindOIDCClient, client.Id, action, func() error {
+			if action == ActionCreate {
+				_, err := ec.CreateOIDCClient(ec.Ctx(), &identity.CreateOIDCClientRequest{Client: &client})
+				if !identity.IsErrAlreadyExists(err) {
+					return err
+				}
+			}
+			_, err := ec.UpdateOIDCClient(ec.Ctx(), &identity.UpdateOIDCClientRequest{Client: &client})
+			return err
+		}); err != nil {
+			return err
+		}
+		delete(existing, client.Id)
+	}
+
+	// anything left in existing wasn't declared
+	for _, id := range sortedClientIDs(existing) {
+		action, ok := undeclaredAction(prune.OIDCClients, prune.KeepOIDCClients, id)
+		if !ok {
+			continue
+		}
+		id := id
+		if err := st.apply(ctx, kindOIDCClient, id, action, func() error {
+			st.log().WithField("client", id).Info("deleting undeclared oidc client")
+			_, err := ec.DeleteOIDCClient(ec.Ctx(), &identity.DeleteOIDCClientRequest{Id: id})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func oidcClientsStep(ctx context.Context, _ *client.APIClient, ec *client.APIClient, st *stepState) error {
+	var clients []identity.OIDCClient
+	if err := st.loadYAML(ctx, oidcClientsPath, &clients); err != nil {
+		return err
+	}
+
+	prune, err := st.config.loadPruneConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	return syncOIDCClients(ctx, ec, clients, prune, st)
+}
+
+func updateOrCreateIDP(ctx context.Context, ec *client.APIClient, connector identity.IDPConnector, existing []*identity.IDPConnector, st *stepState) error {
+	for _, ex := range existing {
+		if ex.Id == connector.Id {
+			// If the connector config hasn't changed, don't update it
+			connector.ConfigVersion = ex.ConfigVersion
+			action := diffAction(true, proto.Equal(ex, &connector))
+
+			// If we are updating the connector, increment the version
+			connector.ConfigVersion = ex.ConfigVersion + 1
+			return st.apply(ctx, kindIDPConnector, connector.Id, action, func() error {
+				_, err := ec.UpdateIDPConnector(ec.Ctx(), &identity.UpdateIDPConnectorRequest{Connector: &connector})
+				return err
+			})
+		}
+	}
+
+	return st.apply(ctx, kindIDPConnector, connector.Id, ActionCreate, func() error {
+		_, err := ec.CreateIDPConnector(ec.Ctx(), &identity.CreateIDPConnectorRequest{Connector: &connector})
+		return err
+	})
+}
+
+func idpsStep(ctx context.Context, _ *client.APIClient, ec *client.APIClient, st *stepState) error {
+	var connectors []identity.IDPConnector
+	if err := st.loadYAML(ctx, idpsPath, &connectors); err != nil {
+		return err
+	}
+
+	// Normally IDP config requires a "ConfigVersion" to be incremented, but when users
+	// are using the config pod we should just apply the latest version
+	var existing []*identity.IDPConnector
+	resp, err := ec.ListIDPConnectors(ec.Ctx(), &identity.ListIDPConnectorsRequest{})
+	if err != nil && !isErrNotActivated(err) {
+		return err
+	}
+	if err == nil {
+		existing = resp.Connectors
+	}
+
+	declared := make(map[string]bool)
+	for _, connector := range connectors {
+		if err := updateOrCreateIDP(ctx, ec, connector, existing, st); err != nil {
+			return err
+		}
+		declared[connector.Id] = true
+	}
+
+	prune, err := st.config.loadPruneConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, ex := range existing {
+		if declared[ex.Id] {
+			continue
+		}
+		action, ok := undeclaredAction(prune.IDPs, prune.KeepIDPs, ex.Id)
+		if !ok {
+			continue
+		}
+		id := ex.Id
+		if err := st.apply(ctx, kindIDPConnector, id, action, func() error {
+			st.log().WithField("connector", id).Info("deleting undeclared idp connector")
+			_, err := ec.DeleteIDPConnector(ec.Ctx(), &identity.DeleteIDPConnectorRequest{Id: id})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rolesEqual returns true if a principal's existing roles are exactly roles
+func rolesEqual(existing *auth.Roles, roles []string) bool {
+	if existing == nil || len(existing.Roles) != len(roles) {
+		return false
+	}
+	for _, r := range roles {
+		if !existing.Roles[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// syncRoleBinding makes the role binding on resource match binding. Principals
+// which aren't listed are removed, except for `pach:` principals which can't
+// be modified. Items are recorded as kind, with ids prefixed by idPrefix.
+func syncRoleBinding(ctx context.Context, c *client.APIClient, resource *auth.Resource, kind, idPrefix string, binding map[string][]string, st *stepState) error {
+	existing, err := getRoleBinding(c, resource)
+	if err != nil {
+		return err
+	}
+
+	return applyRoleBinding(ctx, c, resource, kind, idPrefix, binding, existing, st)
+}
+
+// getRoleBinding returns the current role binding on resource, which is empty
+// if auth isn't active yet
+func getRoleBinding(c *client.APIClient, resource *auth.Resource) (map[string]*auth.Roles, error) {
+	resp, err := c.GetRoleBinding(c.Ctx(), &auth.GetRoleBindingRequest{
+		Resource: resource,
+	})
+	if err != nil {
+		if isErrNotActivated(err) {
+			return make(map[string]*auth.Roles), nil
+		}
+		return nil, err
+	}
+	return resp.Binding.Entries, nil
+}
+
+func sortedPrincipals(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedBindingPrincipals(m map[string]*auth.Roles) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func applyRoleBinding(ctx context.Context, c *client.APIClient, resource *auth.Resource, kind, idPrefix string, binding map[string][]string, existing map[string]*auth.Roles, st *stepState) error {
+	// plans and reports list the principals in order, rather than map order
+	for _, p := range sortedBindingPrincipals(existing) {
+		// `pach:` user role bindings cannot be modified
+		if strings.HasPrefix(p, auth.PachPrefix) {
+			continue
+		}
+
+		if _, ok := binding[p]; !ok {
+			p := p
+			if err := st.apply(ctx, kind, idPrefix+p, ActionDelete, func() error {
+				_, err := c.ModifyRoleBinding(c.Ctx(), &auth.ModifyRoleBindingRequest{
+					Resource:  resource,
+					Principal: p,
+				})
+				return err
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, p := range sortedPrincipals(binding) {
+		ex, exists := existing[p]
+		r := binding[p]
+		if err := st.apply(ctx, kind, idPrefix+p, diffAction(exists, rolesEqual(ex, r)), func() error {
+			_, err := c.ModifyRoleBinding(c.Ctx(), &auth.ModifyRoleBindingRequest{
+				Resource:  resource,
+				Principal: p,
+				Roles:     r,
+			})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func roleBindingsStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	var roleBinding map[string][]string
+	if err := st.loadYAML(ctx, clusterRoleBindingsPath, &roleBinding); err != nil {
+		return err
+	}
+
+	return syncRoleBinding(ctx, c, &auth.Resource{Type: auth.ResourceType_CLUSTER}, kindClusterRoleBinding, "", roleBinding, st)
+}
+
+// repoRoleBindingsStep syncs the role bindings of each listed repo. Repos
+// which aren't listed are left alone.
+func repoRoleBindingsStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	var repoRoleBindings map[string]map[string][]string
+	if err := st.loadYAML(ctx, repoRoleBindingsPath, &repoRoleBindings); err != nil {
+		return err
+	}
+
+	repos := make([]string, 0, len(repoRoleBindings))
+	for repo := range repoRoleBindings {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	// in a dry run, repos declared in the repos key may not have been
+	// created yet, so only the existing repos' bindings can be read
+	var existingRepos map[string]string
+	if st.dryRun {
+		var err error
+		if existingRepos, err = listRepos(c); err != nil {
+			return err
+		}
+	}
+
+	for _, repo := range repos {
+		resource := &auth.Resource{Type: auth.ResourceType_REPO, Name: repo}
+
+		existing := make(map[string]*auth.Roles)
+		if _, ok := existingRepos[repo]; ok || !st.dryRun {
+			var err error
+			if existing, err = getRoleBinding(c, resource); err != nil {
+				return err
+			}
+		}
+
+		if err := applyRoleBinding(ctx, c, resource, kindRepoRoleBinding, repo+"/", repoRoleBindings[repo], existing, st); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repoConfig is an entry in the repos config key
+type repoConfig struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// listRepos returns the descriptions of the user repos in pachd, keyed by name
+func listRepos(c *client.APIClient) (map[string]string, error) {
+	infos, err := c.ListRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, info := range infos {
+		result[info.Repo.Name] = info.Description
+	}
+	return result, nil
+}
+
+func reposStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	var repos []repoConfig
+	if err := st.loadYAML(ctx, reposPath, &repos); err != nil {
+		return err
+	}
+
+	existing, err := listRepos(c)
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		description, exists := existing[repo.Name]
+		repo := repo
+		if err := st.apply(ctx, kindRepo, repo.Name, diffAction(exists, description == repo.Description), func() error {
+			_, err := c.PfsAPIClient.CreateRepo(c.Ctx(), &pfs.CreateRepoRequest{
+				Repo:        client.NewRepo(repo.Name),
+				Description: repo.Description,
+				Update:      true,
+			})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func enterpriseConfigStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	var config enterprise.ActivateRequest
+	if err := st.loadYAML(ctx, enterpriseConfigPath, &config); err != nil {
+		return err
+	}
+
+	action, err := enterpriseConfigAction(c)
+	if err != nil {
+		return err
+	}
+
+	return st.apply(ctx, kindEnterpriseConfig, config.Id, action, func() error {
+		_, err := c.Enterprise.Activate(c.Ctx(), &config)
+		return err
+	})
+}
+
+func authConfigStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	var config auth.OIDCConfig
+	if err := st.loadYAML(ctx, authConfigPath, &config); err != nil {
+		return err
+	}
+
+	existing, err := c.GetConfiguration(c.Ctx(), &auth.GetConfigurationRequest{})
+	if err != nil && !isErrNotActivated(err) {
+		return err
+	}
+
+	action := diffAction(err == nil, err == nil && proto.Equal(existing.Configuration, &config))
+	return st.apply(ctx, kindAuthConfig, config.ClientID, action, func() error {
+		_, err := c.SetConfiguration(c.Ctx(), &auth.SetConfigurationRequest{Configuration: &config})
+		return err
+	})
+}
+
+func activateAuthStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	rootToken, err := st.loadResolvable(ctx, rootTokenPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.WhoAmI(c.Ctx(), &auth.WhoAmIRequest{})
+	if err != nil && !auth.IsErrNotActivated(err) {
+		return err
+	}
+
+	return st.apply(ctx, kindAuth, "root token", diffAction(err == nil, true), func() error {
+		_, err := c.Activate(c.Ctx(), &auth.ActivateRequest{
+			RootToken: string(rootToken),
+		})
+
+		if err != nil {
+			if auth.IsErrAlreadyActivated(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if _, err := c.PfsAPIClient.ActivateAuth(c.Ctx(), &pfs.ActivateAuthRequest{}); err != nil {
+			return err
+		}
+
+		if _, err := c.PpsAPIClient.ActivateAuth(c.Ctx(), &pps.ActivateAuthRequest{}); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func identityServiceConfigStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	var config identity.IdentityServerConfig
+	if err := st.loadYAML(ctx, identityServiceConfigPath, &config); err != nil {
+		return err
+	}
+
+	existing, err := c.GetIdentityServerConfig(c.Ctx(), &identity.GetIdentityServerConfigRequest{})
+	if err != nil && !isErrNotActivated(err) {
+		return err
+	}
+
+	action := diffAction(err == nil, err == nil && proto.Equal(existing.Config, &config))
+	return st.apply(ctx, kindIdentityConfig, config.Issuer, action, func() error {
+		_, err := c.SetIdentityServerConfig(c.Ctx(), &identity.SetIdentityServerConfigRequest{Config: &config})
+		return err
+	})
+}
+
+// specHashAnnotation is set on every pipeline created by config-pod to the
+// hash of the spec it was created from, because pachd fills in defaults which
+// make comparing the spec with the pipeline's current details unreliable
+const specHashAnnotation = "config-pod.pachyderm.io/spec-hash"
+
+// pipelineSpec is a pipeline spec loaded from the pipelines directory
+type pipelineSpec struct {
+	request *pps.CreatePipelineRequest
+	hash    string
+}
+
+// loadPipelineSpecs loads the JSON or YAML pipeline specs in the pipelines
+// directory of the config root, in file name order
+func (c *Config) loadPipelineSpecs() ([]pipelineSpec, error) {
+	entries, err := fs.ReadDir(c.fsys, pipelinesPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w - no directory %s", ErrSkipped, c.name(pipelinesPath))
+		}
+		return nil, err
+	}
+
+	var specs []pipelineSpec
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+
+		// pipeline specs aren't interpolated, since their commands use the
+		// shell's own ${VAR}s, which must reach the pipeline as written
+		data, err := fs.ReadFile(c.fsys, pipelinesPath+"/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		// JSON is valid YAML, so both formats are parsed the same way
+		jsonData, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pipeline spec %s: %w", e.Name(), err)
+		}
+
+		var request pps.CreatePipelineRequest
+		if err := jsonpb.Unmarshal(bytes.NewReader(jsonData), &request); err != nil {
+			return nil, fmt.Errorf("invalid pipeline spec %s: %w", e.Name(), err)
+		}
+		if request.Pipeline == nil || request.Pipeline.Name == "" {
+			return nil, fmt.Errorf("invalid pipeline spec %s: no pipeline name", e.Name())
+		}
+
+		hash, err := pipelineSpecHash(&request)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, pipelineSpec{request: &request, hash: hash})
+	}
+	return specs, nil
+}
+
+// pipelineSpecHash returns a hash of the canonical JSON form of a pipeline spec
+func pipelineSpecHash(request *pps.CreatePipelineRequest) (string, error) {
+	canonical, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func pipelinesStep(ctx context.Context, c *client.APIClient, _ *client.APIClient, st *stepState) error {
+	specs, err := st.config.loadPipelineSpecs()
+	if err != nil {
+		return err
+	}
+
+	infos, err := c.ListPipeline(true)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]string)
+	for _, info := range infos {
+		var hash string
+		if info.Details != nil && info.Details.Metadata != nil {
+			hash = info.Details.Metadata.Annotations[specHashAnnotation]
+		}
+		existing[info.Pipeline.Name] = hash
+	}
+
+	for _, spec := range specs {
+		request := spec.request
+		hash, exists := existing[request.Pipeline.Name]
+		action := diffAction(exists, hash == spec.hash)
+
+		if request.Metadata == nil {
+			request.Metadata = &pps.Metadata{}
+		}
+		if request.Metadata.Annotations == nil {
+			request.Metadata.Annotations = make(map[string]string)
+		}
+		request.Metadata.Annotations[specHashAnnotation] = spec.hash
+		// Update creates the pipeline if it doesn't exist, so a pipeline
+		// created since it was listed is updated rather than failing
+		request.Update = true
+
+		if err := st.apply(ctx, kindPipeline, request.Pipeline.Name, action, func() error {
+			_, err := c.PpsAPIClient.CreatePipeline(c.Ctx(), request)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
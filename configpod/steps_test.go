@@ -0,0 +1,889 @@
+package configpod
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+	"github.com/pachyderm/pachyderm/v2/src/client"
+	"github.com/pachyderm/pachyderm/v2/src/enterprise"
+	"github.com/pachyderm/pachyderm/v2/src/identity"
+	"github.com/pachyderm/pachyderm/v2/src/license"
+
+	"github.com/pachyderm/config-pod/testpachd"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+var (
+	testRootToken  = "testroottoken"
+	testIssuer     = "http://localhost:30658/"
+	testOIDCSecret = "oidcsecret"
+	testRedirect   = "http://localhost:30657/redirect"
+
+	oidcConfig          = localhostOIDCConfig(testIssuer, testOIDCSecret, testRedirect)
+	pachydermOIDCClient = localhostOIDCClient(testOIDCSecret, testRedirect, []string(nil))
+)
+
+func localhostOIDCClient(secret, redirect string, trustedPeers []string) identity.OIDCClient {
+	return identity.OIDCClient{
+		Id:           "pachd",
+		Name:         "pachd",
+		Secret:       secret,
+		RedirectUris: []string{redirect},
+		TrustedPeers: trustedPeers,
+	}
+}
+
+func localhostOIDCConfig(issuer, secret, redirect string) auth.OIDCConfig {
+	return auth.OIDCConfig{
+		Issuer:          issuer,
+		ClientID:        "pachd",
+		ClientSecret:    secret,
+		RedirectURI:     redirect,
+		LocalhostIssuer: true,
+		Scopes:          auth.DefaultOIDCScopes,
+	}
+}
+
+type StepTestSuite struct {
+	suite.Suite
+	c *client.APIClient
+	// config is a new, empty config root for each test
+	config *Config
+
+	// pachd is an in-memory pachd, used unless PACH_ADDRESS is set
+	pachd *testpachd.Pachd
+}
+
+func (s *StepTestSuite) RequireNilOrSkipped(err error) {
+	s.T().Helper()
+	s.Require().True(err == nil || errors.Is(err, ErrSkipped))
+}
+
+func (s *StepTestSuite) writeFile(filename string, data []byte) {
+	s.Require().NoError(ioutil.WriteFile(path.Join(s.config.root, filename), data, os.ModePerm))
+}
+
+// state returns the state for running a step directly, without a Syncer
+func (s *StepTestSuite) state() *stepState {
+	return &stepState{config: s.config}
+}
+
+// sync runs the sync steps against the test pachd
+func (s *StepTestSuite) sync(ctx context.Context, opts ...Option) (*Result, error) {
+	syncer, err := NewSyncer(append([]Option{WithConfig(s.config), WithClient(s.c)}, opts...)...)
+	s.Require().NoError(err)
+	return syncer.Run(ctx)
+}
+
+func (s *StepTestSuite) writeYAML(filename string, data interface{}) {
+	yamlData, err := yaml.Marshal(data)
+	s.Require().NoError(err)
+	s.writeFile(filename, yamlData)
+}
+
+func TestStepTestSuite(t *testing.T) {
+	suite.Run(t, new(StepTestSuite))
+}
+
+// SetupSuite connects to the pachd at PACH_ADDRESS, if it's set. Otherwise
+// each test gets a new in-memory pachd.
+func (s *StepTestSuite) SetupSuite() {
+	if addr := os.Getenv("PACH_ADDRESS"); addr != "" {
+		var err error
+		s.c, err = client.NewFromURI(addr)
+		s.Require().NoError(err)
+		s.c.SetAuthToken(testRootToken)
+	}
+}
+
+func (s *StepTestSuite) SetupTest() {
+	if s.c == nil || s.pachd != nil {
+		s.startTestPachd()
+	}
+
+	s.Require().NoError(s.c.DeleteAll())
+	root, err := ioutil.TempDir("", "example")
+	s.Require().NoError(err)
+	s.config = NewConfig(root)
+}
+
+func (s *StepTestSuite) TearDownSuite() {
+	if s.pachd != nil {
+		s.Require().NoError(s.pachd.Close())
+	}
+}
+
+func (s *StepTestSuite) startTestPachd() {
+	if s.pachd != nil {
+		s.Require().NoError(s.pachd.Close())
+	}
+	var err error
+	s.pachd, err = testpachd.New()
+	s.Require().NoError(err)
+	s.c = s.pachd.Client()
+	s.c.SetAuthToken(testRootToken)
+}
+
+// activationCode returns the enterprise activation code to test with. Any
+// code is accepted by the in-memory pachd.
+func (s *StepTestSuite) activationCode() []byte {
+	if code := os.Getenv("ENT_ACT_CODE"); code != "" || s.pachd == nil {
+		return []byte(code)
+	}
+	return []byte("test-activation-code")
+}
+
+// TestSkipStep tests that every step raises ErrSkipped if there's no configuration
+func (s *StepTestSuite) TestSkipStep() {
+	for _, step := range syncSteps {
+		err := step.fn(context.Background(), s.c, s.c, s.state())
+		s.Require().ErrorIs(err, ErrSkipped)
+	}
+}
+
+// writeSimpleConfig writes a simple, minimal config for a single node
+func (s *StepTestSuite) writeSimpleConfig() {
+	// write out an enterprise token
+	s.writeFile(licensePath, s.activationCode())
+
+	// write out the root token
+	s.writeFile(rootTokenPath, []byte(testRootToken))
+
+	// configure enterprise secret
+	s.writeFile(enterpriseSecretPath, []byte("enterpriseSecret"))
+
+	// configure test issuer
+	s.writeYAML(identityServiceConfigPath, identity.IdentityServerConfig{Issuer: testIssuer})
+
+	// configure oidc client
+	s.writeYAML(oidcClientsPath, []identity.OIDCClient{pachydermOIDCClient})
+
+	// configure auth config
+	s.writeYAML(authConfigPath, oidcConfig)
+}
+
+// TestSimpleConfig tests configuring a single pachd with only the simple config
+func (s *StepTestSuite) TestSimpleConfig() {
+	s.writeSimpleConfig()
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	// check that we're authenticated as the root user and auth is active
+	resp, err := s.c.WhoAmI(s.c.Ctx(), &auth.WhoAmIRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(resp.Username, "pach:root")
+
+	clients, err := s.c.ListOIDCClients(s.c.Ctx(), &identity.ListOIDCClientsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(1, len(clients.Clients))
+	s.Require().Nil(clients.Clients[0].TrustedPeers)
+	clients.Clients[0].TrustedPeers = []string(nil)
+	s.Require().Equal(&pachydermOIDCClient, clients.Clients[0])
+
+	authConfig, err := s.c.GetConfiguration(s.c.Ctx(), &auth.GetConfigurationRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(&oidcConfig, authConfig.Configuration)
+
+}
+
+// TestFullConfig tests explicitly setting the IDP and OIDC config, rather than using the simple config
+func (s *StepTestSuite) TestFullConfig() {
+	// write out an enterprise token
+	s.writeFile(licensePath, s.activationCode())
+
+	// write out the root token
+	s.writeFile(rootTokenPath, []byte(testRootToken))
+
+	// configure the identity service
+	s.writeYAML(identityServiceConfigPath, identity.IdentityServerConfig{
+		Issuer: testIssuer,
+	})
+
+	s.writeYAML(authConfigPath, oidcConfig)
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	authConfig, err := s.c.GetConfiguration(s.c.Ctx(), &auth.GetConfigurationRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(&oidcConfig, authConfig.Configuration)
+}
+
+func (s *StepTestSuite) TestRoleBindings() {
+	s.writeSimpleConfig()
+
+	// add a role binding
+	s.writeYAML(clusterRoleBindingsPath, map[string][]string{
+		"robot:test": []string{"repoReader"},
+	})
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	roleBinding, err := s.c.GetRoleBinding(s.c.Ctx(), &auth.GetRoleBindingRequest{
+		Resource: &auth.Resource{Type: auth.ResourceType_CLUSTER},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(map[string]*auth.Roles{
+		"pach:root":  &auth.Roles{Roles: map[string]bool{"clusterAdmin": true}},
+		"robot:test": &auth.Roles{Roles: map[string]bool{"repoReader": true}},
+	}, roleBinding.Binding.Entries)
+
+	s.writeYAML(clusterRoleBindingsPath, map[string][]string{
+		"robot:test2": []string{"repoWriter"},
+	})
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	roleBinding, err = s.c.GetRoleBinding(s.c.Ctx(), &auth.GetRoleBindingRequest{
+		Resource: &auth.Resource{Type: auth.ResourceType_CLUSTER},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(map[string]*auth.Roles{
+		"pach:root":   &auth.Roles{Roles: map[string]bool{"clusterAdmin": true}},
+		"robot:test2": &auth.Roles{Roles: map[string]bool{"repoWriter": true}},
+	}, roleBinding.Binding.Entries)
+
+}
+
+// TestRoleBindingsOrder tests that role binding items are planned in
+// principal order, so the same plan is printed every time
+func (s *StepTestSuite) TestRoleBindingsOrder() {
+	s.writeSimpleConfig()
+	s.writeYAML(clusterRoleBindingsPath, map[string][]string{
+		"robot:d": []string{"repoReader"},
+		"robot:b": []string{"repoReader"},
+		"robot:f": []string{"repoReader"},
+	})
+	_, err := s.sync(context.Background())
+	s.Require().NoError(err)
+
+	s.writeYAML(clusterRoleBindingsPath, map[string][]string{
+		"robot:e": []string{"repoReader"},
+		"robot:a": []string{"repoReader"},
+		"robot:c": []string{"repoReader"},
+	})
+	for i := 0; i < 10; i++ {
+		report, err := s.sync(context.Background(), WithDryRun(), WithOnly("sync cluster role bindings"))
+		s.Require().NoError(err)
+		var ids []string
+		for _, step := range report.Steps {
+			for _, item := range step.Items {
+				ids = append(ids, item.Action.Symbol()+item.ID)
+			}
+		}
+		s.Require().Equal([]string{"-robot:b", "-robot:d", "-robot:f", "+robot:a", "+robot:c", "+robot:e"}, ids)
+	}
+}
+
+func (s *StepTestSuite) TestIDPs() {
+	s.writeSimpleConfig()
+
+	mockIDPConnector := identity.IDPConnector{
+		Name:       "test",
+		Id:         "test",
+		Type:       "mockPassword",
+		JsonConfig: `{"username": "admin", "password": "password"}`,
+	}
+
+	// configure an IDP connector
+	s.writeYAML(idpsPath, []identity.IDPConnector{mockIDPConnector})
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	idps, err := s.c.ListIDPConnectors(s.c.Ctx(), &identity.ListIDPConnectorsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(1, len(idps.Connectors))
+	s.Require().Equal(&mockIDPConnector, idps.Connectors[0])
+
+	mockIDPConnector.Name = "updated"
+
+	s.writeYAML(idpsPath, []identity.IDPConnector{mockIDPConnector})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	idps, err = s.c.ListIDPConnectors(s.c.Ctx(), &identity.ListIDPConnectorsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(1, len(idps.Connectors))
+	mockIDPConnector.ConfigVersion = 1
+	s.Require().Equal(&mockIDPConnector, idps.Connectors[0])
+}
+
+// TestOIDCClients tests configuring additional OIDC clients
+func (s *StepTestSuite) TestOIDCClients() {
+	s.writeSimpleConfig()
+
+	newClient := identity.OIDCClient{
+		Id:           "new",
+		RedirectUris: []string{"http://other:1657/authorization-code/callback"},
+		Name:         "new",
+		Secret:       "secret",
+	}
+
+	// test that oidcClient.Secret value resolves if it's an environment variable
+	newClientWithEnvVarSecret := identity.OIDCClient{
+		Id:           "withEnvVarSecret",
+		RedirectUris: []string{"http://other:1657/authorization-code/callback"},
+		Name:         "withEnvVarSecret",
+		Secret:       "$TEST_SECRET",
+	}
+	expectedNewClientWithEnvVarSecret := newClientWithEnvVarSecret
+
+	s.Require().NoError(os.Setenv("TEST_SECRET", "test_secret_value"))
+	expectedNewClientWithEnvVarSecret.Secret = "test_secret_value"
+
+	s.writeYAML(oidcClientsPath, []identity.OIDCClient{pachydermOIDCClient, newClient, newClientWithEnvVarSecret})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	clients, err := s.c.ListOIDCClients(s.c.Ctx(), &identity.ListOIDCClientsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(3, len(clients.Clients))
+	s.Require().Equal(&pachydermOIDCClient, clients.Clients[0])
+	s.Require().Equal(&newClient, clients.Clients[1])
+	s.Require().Equal(&expectedNewClientWithEnvVarSecret, clients.Clients[2])
+
+	newClient.Name = "updated"
+
+	s.writeYAML(oidcClientsPath, []identity.OIDCClient{newClient})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	clients, err = s.c.ListOIDCClients(s.c.Ctx(), &identity.ListOIDCClientsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(3, len(clients.Clients))
+	s.Require().Equal(&newClient, clients.Clients[2])
+}
+
+// TestEnterpriseConfig tests configuring a pachd to talk to an external enterprise server
+func (s *StepTestSuite) TestEnterpriseConfig() {
+	externalEnterpriseCluster := license.AddClusterRequest{
+		Id:                  "external",
+		Address:             "grpc://localhost:1653",
+		UserAddress:         "grpc://localhost:1653",
+		Secret:              "externalSecret",
+		ClusterDeploymentId: "cluster-deployment-1",
+	}
+
+	s.writeFile(licensePath, s.activationCode())
+	s.writeYAML(enterpriseClustersPath, []license.AddClusterRequest{externalEnterpriseCluster})
+	s.writeYAML(enterpriseConfigPath, enterprise.ActivateRequest{
+		Id:            "external",
+		LicenseServer: "grpc://localhost:1653",
+		Secret:        "externalSecret",
+	})
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	clusters, err := s.c.License.ListClusters(s.c.Ctx(), &license.ListClustersRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(1, len(clusters.Clusters))
+
+	state, err := s.c.Enterprise.GetState(s.c.Ctx(), &enterprise.GetStateRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(enterprise.State_ACTIVE, state.State)
+
+	updatedCluster := externalEnterpriseCluster
+	updatedCluster.Address = "grpc://localhost:1650"
+
+	newCluster := license.AddClusterRequest{
+		Id:                  "external2",
+		Address:             "grpc://localhost:1653",
+		UserAddress:         "grpc://external2:1653",
+		Secret:              "externalSecret2",
+		ClusterDeploymentId: "$CLUSTER_2_DEPLOYMENT",
+	}
+	// testing that ClusterDeploymentId can reference an environment variable
+	os.Setenv("CLUSTER_2_DEPLOYMENT", "refrenced-depoyment-id")
+
+	s.writeYAML(enterpriseClustersPath, []license.AddClusterRequest{updatedCluster, newCluster})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	clusters, err = s.c.License.ListClusters(s.c.Ctx(), &license.ListClustersRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(clusters.Clusters))
+	s.Require().Equal("grpc://localhost:1653", clusters.Clusters[0].Address)
+	s.Require().Equal("grpc://localhost:1650", clusters.Clusters[1].Address)
+
+	userClusters, err := s.c.License.ListUserClusters(s.c.Ctx(), &license.ListUserClustersRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(userClusters.Clusters))
+	s.Require().Equal("refrenced-depoyment-id", userClusters.Clusters[0].ClusterDeploymentId)
+	s.Require().Equal("cluster-deployment-1", userClusters.Clusters[1].ClusterDeploymentId)
+}
+
+// TestPlan tests that a dry run reports changes without applying them
+func (s *StepTestSuite) TestPlan() {
+	s.writeSimpleConfig()
+
+	plan := func() map[string]Action {
+		actions := make(map[string]Action)
+		for _, step := range syncSteps {
+			st := &stepState{dryRun: true, config: s.config}
+			s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, st))
+			for _, c := range st.changes {
+				actions[c.Kind+"/"+c.ID] = c.Action
+			}
+		}
+		return actions
+	}
+
+	actions := plan()
+	s.Require().Equal(ActionCreate, actions[kindLicense+"/activation code"])
+	s.Require().Equal(ActionCreate, actions[kindAuth+"/root token"])
+	s.Require().Equal(ActionCreate, actions[kindOIDCClient+"/pachd"])
+	s.Require().Equal(ActionCreate, actions[kindAuthConfig+"/pachd"])
+
+	// nothing should have been applied
+	_, err := s.c.WhoAmI(s.c.Ctx(), &auth.WhoAmIRequest{})
+	s.Require().True(auth.IsErrNotActivated(err))
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	actions = plan()
+	s.Require().Equal(ActionUnchanged, actions[kindLicense+"/activation code"])
+	s.Require().Equal(ActionUnchanged, actions[kindAuth+"/root token"])
+	s.Require().Equal(ActionUnchanged, actions[kindOIDCClient+"/pachd"])
+	s.Require().Equal(ActionUnchanged, actions[kindAuthConfig+"/pachd"])
+	s.Require().Equal(ActionUnchanged, actions[kindIdentityConfig+"/"+testIssuer])
+}
+
+// TestPruneOIDCClients tests that undeclared OIDC clients are deleted when pruning is enabled
+func (s *StepTestSuite) TestPruneOIDCClients() {
+	s.writeSimpleConfig()
+
+	oldClient := identity.OIDCClient{
+		Id:           "old",
+		RedirectUris: []string{"http://other:1657/authorization-code/callback"},
+		Name:         "old",
+		Secret:       "secret",
+	}
+	newClient := identity.OIDCClient{
+		Id:           "new",
+		RedirectUris: []string{"http://other:1657/authorization-code/callback"},
+		Name:         "new",
+		Secret:       "secret",
+	}
+
+	s.writeYAML(oidcClientsPath, []identity.OIDCClient{pachydermOIDCClient, oldClient, newClient})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	s.writeYAML(oidcClientsPath, []identity.OIDCClient{newClient})
+	s.writeYAML(prunePath, pruneConfig{OIDCClients: true, KeepOIDCClients: []string{"pachd"}})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	clients, err := s.c.ListOIDCClients(s.c.Ctx(), &identity.ListOIDCClientsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(clients.Clients))
+	s.Require().Equal(&pachydermOIDCClient, clients.Clients[0])
+	s.Require().Equal(&newClient, clients.Clients[1])
+}
+
+// TestPruneIDPs tests that undeclared IDP connectors are deleted when pruning is enabled
+func (s *StepTestSuite) TestPruneIDPs() {
+	s.writeSimpleConfig()
+
+	keptConnector := identity.IDPConnector{
+		Name:       "kept",
+		Id:         "kept",
+		Type:       "mockPassword",
+		JsonConfig: `{"username": "admin", "password": "password"}`,
+	}
+	decommissionedConnector := identity.IDPConnector{
+		Name:       "decommissioned",
+		Id:         "decommissioned",
+		Type:       "mockPassword",
+		JsonConfig: `{"username": "admin", "password": "password"}`,
+	}
+
+	s.writeYAML(idpsPath, []identity.IDPConnector{keptConnector, decommissionedConnector})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	s.writeYAML(idpsPath, []identity.IDPConnector{keptConnector})
+	s.writeYAML(prunePath, pruneConfig{IDPs: true})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	idps, err := s.c.ListIDPConnectors(s.c.Ctx(), &identity.ListIDPConnectorsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(1, len(idps.Connectors))
+	s.Require().Equal(&keptConnector, idps.Connectors[0])
+}
+
+// TestPruneEnterpriseClusters tests that undeclared license server clusters
+// are deleted when pruning is enabled, except for the embedded localhost cluster
+func (s *StepTestSuite) TestPruneEnterpriseClusters() {
+	s.writeSimpleConfig()
+
+	keptCluster := license.AddClusterRequest{
+		Id:          "kept",
+		Address:     "grpc://kept:1653",
+		UserAddress: "grpc://kept:1653",
+		Secret:      "keptSecret",
+	}
+	removedCluster := license.AddClusterRequest{
+		Id:          "removed",
+		Address:     "grpc://removed:1653",
+		UserAddress: "grpc://removed:1653",
+		Secret:      "removedSecret",
+	}
+
+	s.writeYAML(enterpriseClustersPath, []license.AddClusterRequest{keptCluster, removedCluster})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	clusters, err := s.c.License.ListClusters(s.c.Ctx(), &license.ListClustersRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(3, len(clusters.Clusters))
+
+	s.writeYAML(enterpriseClustersPath, []license.AddClusterRequest{keptCluster})
+	s.writeYAML(prunePath, pruneConfig{EnterpriseClusters: true})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	clusters, err = s.c.License.ListClusters(s.c.Ctx(), &license.ListClustersRequest{})
+	s.Require().NoError(err)
+	var ids []string
+	for _, c := range clusters.Clusters {
+		ids = append(ids, c.Id)
+	}
+	s.Require().ElementsMatch([]string{localhostEnterpriseClusterId, "kept"}, ids)
+}
+
+// TestRepoRoleBindings tests syncing the role bindings of individual repos
+func (s *StepTestSuite) TestRepoRoleBindings() {
+	s.writeSimpleConfig()
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+	s.Require().NoError(s.c.CreateRepo("images"))
+
+	getRepoRoleBinding := func() map[string]*auth.Roles {
+		roleBinding, err := s.c.GetRoleBinding(s.c.Ctx(), &auth.GetRoleBindingRequest{
+			Resource: &auth.Resource{Type: auth.ResourceType_REPO, Name: "images"},
+		})
+		s.Require().NoError(err)
+		return roleBinding.Binding.Entries
+	}
+
+	s.writeYAML(repoRoleBindingsPath, map[string]map[string][]string{
+		"images": {"robot:test": []string{"repoReader"}},
+	})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	s.Require().Equal(map[string]*auth.Roles{
+		"pach:root":  &auth.Roles{Roles: map[string]bool{"repoOwner": true}},
+		"robot:test": &auth.Roles{Roles: map[string]bool{"repoReader": true}},
+	}, getRepoRoleBinding())
+
+	s.writeYAML(repoRoleBindingsPath, map[string]map[string][]string{
+		"images": {"robot:test2": []string{"repoWriter"}},
+	})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	s.Require().Equal(map[string]*auth.Roles{
+		"pach:root":   &auth.Roles{Roles: map[string]bool{"repoOwner": true}},
+		"robot:test2": &auth.Roles{Roles: map[string]bool{"repoWriter": true}},
+	}, getRepoRoleBinding())
+}
+
+// TestRepos tests creating and updating repos from the repos key
+func (s *StepTestSuite) TestRepos() {
+	s.writeSimpleConfig()
+
+	s.writeYAML(reposPath, []repoConfig{{Name: "images", Description: "raw images"}})
+	s.writeYAML(repoRoleBindingsPath, map[string]map[string][]string{
+		"images": {"robot:test": []string{"repoReader"}},
+	})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	repoInfo, err := s.c.InspectRepo("images")
+	s.Require().NoError(err)
+	s.Require().Equal("raw images", repoInfo.Description)
+
+	roleBinding, err := s.c.GetRoleBinding(s.c.Ctx(), &auth.GetRoleBindingRequest{
+		Resource: &auth.Resource{Type: auth.ResourceType_REPO, Name: "images"},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(&auth.Roles{Roles: map[string]bool{"repoReader": true}}, roleBinding.Binding.Entries["robot:test"])
+
+	s.writeYAML(reposPath, []repoConfig{{Name: "images", Description: "resized images"}})
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	repoInfo, err = s.c.InspectRepo("images")
+	s.Require().NoError(err)
+	s.Require().Equal("resized images", repoInfo.Description)
+}
+
+const testPipelineSpec = `{
+  "pipeline": {"name": "edges"},
+  "description": "detects edges",
+  "input": {"pfs": {"repo": "images", "glob": "/*"}},
+  "transform": {"image": "pachyderm/opencv", "cmd": ["python3", "/edges.py"]}
+}`
+
+// TestLoadPipelineSpecs tests that JSON and YAML pipeline specs parse the same way
+func TestLoadPipelineSpecs(t *testing.T) {
+	root, err := ioutil.TempDir("", "pipelines")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	config := NewConfig(root)
+
+	_, err = config.loadPipelineSpecs()
+	require.ErrorIs(t, err, ErrSkipped)
+
+	yamlSpec, err := yaml.JSONToYAML([]byte(testPipelineSpec))
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(path.Join(root, pipelinesPath), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(root, pipelinesPath, "a.json"), []byte(testPipelineSpec), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(root, pipelinesPath, "b.yaml"), yamlSpec, 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(root, pipelinesPath, "README"), []byte("ignored"), 0644))
+
+	specs, err := config.loadPipelineSpecs()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(specs))
+	require.Equal(t, "edges", specs[0].request.Pipeline.Name)
+	require.Equal(t, []string{"python3", "/edges.py"}, specs[0].request.Transform.Cmd)
+	require.Equal(t, "images", specs[0].request.Input.Pfs.Repo)
+	require.Equal(t, specs[0].hash, specs[1].hash)
+
+	// a shell variable in a spec is left for the pipeline's shell to expand
+	require.NoError(t, ioutil.WriteFile(path.Join(root, pipelinesPath, "c.yaml"), []byte(`
+pipeline: {name: shell}
+transform:
+  cmd: [sh]
+  stdin: ["echo ${HOME} > /pfs/out/x", "echo ${CONFIG_POD_UNSET_VAR}"]
+`), 0644))
+	specs, err = config.loadPipelineSpecs()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(specs))
+	require.Equal(t, []string{"echo ${HOME} > /pfs/out/x", "echo ${CONFIG_POD_UNSET_VAR}"}, specs[2].request.Transform.Stdin)
+}
+
+// TestPipelines tests creating and updating pipelines from the pipelines directory
+func (s *StepTestSuite) TestPipelines() {
+	s.writeSimpleConfig()
+	s.writeYAML(reposPath, []repoConfig{{Name: "images"}})
+	s.Require().NoError(os.Mkdir(path.Join(s.config.root, pipelinesPath), os.ModePerm))
+	s.writeFile(path.Join(pipelinesPath, "edges.json"), []byte(testPipelineSpec))
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	pipelineInfo, err := s.c.InspectPipeline("edges", true)
+	s.Require().NoError(err)
+	s.Require().Equal("detects edges", pipelineInfo.Details.Description)
+
+	st := &stepState{dryRun: true, config: s.config}
+	s.Require().NoError(pipelinesStep(context.Background(), s.c, s.c, st))
+	s.Require().Equal([]change{{Kind: kindPipeline, ID: "edges", Action: ActionUnchanged}}, st.changes)
+
+	s.writeFile(path.Join(pipelinesPath, "edges.json"), []byte(strings.Replace(testPipelineSpec, "detects edges", "finds edges", 1)))
+	st = s.state()
+	s.Require().NoError(pipelinesStep(context.Background(), s.c, s.c, st))
+	s.Require().Equal([]change{{Kind: kindPipeline, ID: "edges", Action: ActionUpdate}}, st.changes)
+
+	pipelineInfo, err = s.c.InspectPipeline("edges", true)
+	s.Require().NoError(err)
+	s.Require().Equal("finds edges", pipelineInfo.Details.Description)
+}
+
+// TestRunInterrupted tests that no steps are applied once the context
+// passed to Run has been cancelled
+func (s *StepTestSuite) TestRunInterrupted() {
+	s.writeSimpleConfig()
+	s.writeYAML(reposPath, []repoConfig{{Name: "images"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := s.sync(ctx)
+	s.Require().True(errors.Is(err, context.Canceled))
+
+	s.Require().Equal("failed", report.Outcome)
+	s.Require().Equal(len(syncSteps), len(report.Steps))
+	s.Require().Equal(OutcomeFailed, report.Steps[0].Outcome)
+	for _, step := range report.Steps[1:] {
+		s.Require().Equal(OutcomeNotRun, step.Outcome)
+	}
+
+	repos, err := s.c.ListRepo()
+	s.Require().NoError(err)
+	s.Require().Equal(0, len(repos))
+}
+
+// TestExport tests that the exported config matches what was applied, with
+// secrets replaced, and is itself a valid config root
+func (s *StepTestSuite) TestExport() {
+	s.writeSimpleConfig()
+	s.writeYAML(clusterRoleBindingsPath, map[string][]string{
+		"robot:test": []string{"repoReader", "repoWriter"},
+	})
+
+	for _, step := range syncSteps {
+		s.RequireNilOrSkipped(step.fn(context.Background(), s.c, s.c, s.state()))
+	}
+
+	e := &Exporter{}
+	files, err := e.Export(s.c, s.c)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{
+		"OIDC_CLIENT_PACHD_SECRET",
+		"AUTH_CONFIG_CLIENT_SECRET",
+	}, e.EnvVars)
+
+	// the embedded enterprise server's cluster isn't exported
+	var clusters []license.AddClusterRequest
+	s.Require().NoError(yaml.Unmarshal(files[enterpriseClustersPath], &clusters))
+	s.Require().Empty(clusters)
+
+	var clients []identity.OIDCClient
+	s.Require().NoError(yaml.Unmarshal(files[oidcClientsPath], &clients))
+	expected := pachydermOIDCClient
+	expected.Secret = "env:OIDC_CLIENT_PACHD_SECRET"
+	s.Require().Equal([]identity.OIDCClient{expected}, clients)
+
+	var bindings map[string][]string
+	s.Require().NoError(yaml.Unmarshal(files[clusterRoleBindingsPath], &bindings))
+	s.Require().Equal(map[string][]string{"robot:test": []string{"repoReader", "repoWriter"}}, bindings)
+
+	var config auth.OIDCConfig
+	s.Require().NoError(yaml.Unmarshal(files[authConfigPath], &config))
+	s.Require().Equal("env:AUTH_CONFIG_CLIENT_SECRET", config.ClientSecret)
+
+	// the export is a valid config root
+	root, err := ioutil.TempDir("", "export")
+	s.Require().NoError(err)
+	defer os.RemoveAll(root)
+	s.Require().NoError(WriteConfigDir(root, files))
+	s.Require().Empty(NewConfig(root).Validate())
+
+	var manifest strings.Builder
+	s.Require().NoError(WriteSecretManifest(&manifest, "pachyderm-config", files))
+	s.Require().Contains(manifest.String(), "\n  name: pachyderm-config\nstringData:\n  authConfig: |\n    ")
+	s.Require().Contains(manifest.String(), "\n  oidcClients: |\n    - id: pachd\n")
+}
+
+// TestCheck tests that a dry run reports no drift after the config has been
+// applied, and reports changes made to the cluster outside of config-pod
+func (s *StepTestSuite) TestCheck() {
+	s.writeSimpleConfig()
+	s.writeYAML(clusterRoleBindingsPath, map[string][]string{
+		"robot:test": []string{"repoReader"},
+	})
+
+	_, err := s.sync(context.Background())
+	s.Require().NoError(err)
+
+	report, err := s.sync(context.Background(), WithDryRun())
+	s.Require().NoError(err)
+	s.Require().Empty(driftedItems(report))
+
+	// change the role binding behind config-pod's back
+	_, err = s.c.ModifyRoleBinding(s.c.Ctx(), &auth.ModifyRoleBindingRequest{
+		Resource:  &auth.Resource{Type: auth.ResourceType_CLUSTER},
+		Principal: "robot:test",
+		Roles:     []string{"repoWriter"},
+	})
+	s.Require().NoError(err)
+
+	report, err = s.sync(context.Background(), WithDryRun())
+	s.Require().NoError(err)
+	s.Require().Equal([]ItemResult{
+		{Kind: kindClusterRoleBinding, ID: "robot:test", Action: ActionUpdate},
+	}, driftedItems(report))
+}
+
+// TestCheckUndeclared tests that a dry run reports an IDP connector added
+// outside of config-pod as drift, and that applying the config leaves it
+// alone, since IDPs aren't pruned
+func (s *StepTestSuite) TestCheckUndeclared() {
+	s.writeSimpleConfig()
+	declared := identity.IDPConnector{
+		Name:       "declared",
+		Id:         "declared",
+		Type:       "mockPassword",
+		JsonConfig: `{"username": "admin", "password": "password"}`,
+	}
+	s.writeYAML(idpsPath, []identity.IDPConnector{declared})
+
+	_, err := s.sync(context.Background())
+	s.Require().NoError(err)
+
+	added := identity.IDPConnector{
+		Name:       "added",
+		Id:         "added",
+		Type:       "mockPassword",
+		JsonConfig: `{"username": "admin", "password": "password"}`,
+	}
+	_, err = s.c.CreateIDPConnector(s.c.Ctx(), &identity.CreateIDPConnectorRequest{Connector: &added})
+	s.Require().NoError(err)
+
+	report, err := s.sync(context.Background(), WithDryRun())
+	s.Require().NoError(err)
+	s.Require().Equal([]ItemResult{
+		{Kind: kindIDPConnector, ID: "added", Action: ActionUndeclared},
+	}, driftedItems(report))
+
+	_, err = s.sync(context.Background())
+	s.Require().NoError(err)
+	idps, err := s.c.ListIDPConnectors(s.c.Ctx(), &identity.ListIDPConnectorsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(idps.Connectors))
+}
+
+// driftedItems returns the drifted items in a dry run's result
+func driftedItems(r *Result) []ItemResult {
+	var drifted []ItemResult
+	for _, step := range r.Steps {
+		for _, item := range step.Items {
+			if item.Drifted() {
+				drifted = append(drifted, item)
+			}
+		}
+	}
+	return drifted
+}
@@ -0,0 +1,311 @@
+// Package configpod syncs a Pachyderm cluster's configuration with a config
+// root, a directory with a file for each config key. It's the engine behind
+// the config-pod command, for programs which embed it:
+//
+//	syncer, err := configpod.NewSyncer(
+//		configpod.WithConfig(configpod.NewConfig("/pachConfig")),
+//		configpod.WithClient(c),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	result, err := syncer.Run(ctx)
+package configpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pachyderm/pachyderm/v2/src/client"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the 1st client represents the pachd instance that needs to register with an enterprise server represented by the 2nd argument ("ec")
+// in the case of embedded servers, i.e. when the 'enterpriseServerAddress' path isn't populated, the two clients will be the same
+// st records the changes the step makes, and tells it when to only report them
+// both clients are bound to ctx, which is cancelled when the step times out or the run is cancelled
+type clusterSyncFn func(ctx context.Context, c *client.APIClient, ec *client.APIClient, st *stepState) error
+
+type syncStep struct {
+	name  string
+	fn    clusterSyncFn
+	scope stepScope
+	// deps are the names of the steps which must succeed, or be skipped,
+	// before this step runs. An enterprise-scoped step only waits for a
+	// cluster-scoped dependency, which may fail on some targets.
+	deps []string
+}
+
+// syncSteps are run concurrently, each once its dependencies are done. The
+// order here is the order they're logged and reported in.
+var syncSteps = []syncStep{
+	syncStep{"license key", licenseStep, enterpriseScope, nil},
+	syncStep{"enterprise secret", enterpriseSecretStep, enterpriseScope, []string{"license key"}},
+	syncStep{"sync enterprise clusters", enterpriseClustersStep, enterpriseScope, []string{"license key"}},
+	// a pachd can only register with the enterprise server once it's listed as a cluster
+	syncStep{"configure enterprise service", enterpriseConfigStep, clusterScope, []string{"sync enterprise clusters"}},
+	syncStep{"activate authentication", activateAuthStep, clusterScope, []string{"enterprise secret", "configure enterprise service"}},
+	syncStep{"configure identity service", identityServiceConfigStep, clusterScope, []string{"activate authentication"}},
+	syncStep{"sync oidc clients", oidcClientsStep, enterpriseScope, []string{"configure identity service"}},
+	// the auth config names pachd's OIDC client, whose issuer is the
+	// target's identity service
+	syncStep{"configure auth", authConfigStep, clusterScope, []string{"sync oidc clients", "configure identity service"}},
+	syncStep{"sync identity providers", idpsStep, enterpriseScope, []string{"configure identity service"}},
+	syncStep{"sync repos", reposStep, clusterScope, []string{"activate authentication"}},
+	syncStep{"sync cluster role bindings", roleBindingsStep, clusterScope, []string{"activate authentication"}},
+	syncStep{"sync repo role bindings", repoRoleBindingsStep, clusterScope, []string{"sync repos"}},
+	syncStep{"sync pipelines", pipelinesStep, clusterScope, []string{"sync repos", "sync cluster role bindings", "sync repo role bindings"}},
+}
+
+// StepNames returns the names of the sync steps, in the order they're
+// logged and reported in
+func StepNames() []string {
+	names := make([]string, 0, len(syncSteps))
+	for _, step := range syncSteps {
+		names = append(names, step.name)
+	}
+	return names
+}
+
+// The default limits on how long a step, and a whole run, can take
+const (
+	DefaultStepTimeout = 5 * time.Minute
+	DefaultSyncTimeout = 30 * time.Minute
+)
+
+// Syncer applies a config root to a cluster. Create one with NewSyncer.
+type Syncer struct {
+	config  *Config
+	targets []*Target
+	ec      *client.APIClient
+	steps   []syncStep
+	only    []string
+	skip    []string
+
+	// excluded are skipped by Run, because of WithOnly or WithSkip
+	excluded map[string]bool
+
+	dryRun bool
+	// planOutput is where a dry run prints its plan
+	planOutput io.Writer
+	logger     *log.Entry
+
+	// stepTimeout limits each step, and syncTimeout a whole run of the steps.
+	// Zero means no limit.
+	stepTimeout time.Duration
+	syncTimeout time.Duration
+}
+
+// An Option configures a Syncer
+type Option func(*Syncer)
+
+// WithConfig sets the config root the Syncer applies, which is required. It
+// comes from NewConfig for a directory, or NewConfigFS for any other fs.FS.
+func WithConfig(config *Config) Option {
+	return func(s *Syncer) {
+		s.config = config
+	}
+}
+
+// WithClient applies the cluster-scoped steps to the pachd c is connected
+// to, which is also the enterprise server unless WithEnterpriseClient is
+// given. c must be authenticated with the root token, if auth is active.
+func WithClient(c *client.APIClient) Option {
+	return func(s *Syncer) {
+		s.targets = []*Target{{Client: c}}
+	}
+}
+
+// WithTargets applies the cluster-scoped steps to each target, in place of
+// WithClient. Every target must have a Client.
+func WithTargets(targets ...*Target) Option {
+	return func(s *Syncer) {
+		s.targets = targets
+	}
+}
+
+// WithEnterpriseClient applies the enterprise-scoped steps to the
+// enterprise server ec is connected to, which is required with more than
+// one target
+func WithEnterpriseClient(ec *client.APIClient) Option {
+	return func(s *Syncer) {
+		s.ec = ec
+	}
+}
+
+// WithOnly runs only the named steps, skipping every other step. The steps
+// are the fixed set listed by StepNames, and WithOnly and WithSkip are how a
+// Syncer selects which of them to run.
+func WithOnly(steps ...string) Option {
+	return func(s *Syncer) {
+		s.only = append(s.only, steps...)
+	}
+}
+
+// WithSkip skips the named steps
+func WithSkip(steps ...string) Option {
+	return func(s *Syncer) {
+		s.skip = append(s.skip, steps...)
+	}
+}
+
+// WithDryRun reports what each step would change, without changing it
+func WithDryRun() Option {
+	return func(s *Syncer) {
+		s.dryRun = true
+	}
+}
+
+// WithPlanOutput prints a dry run's plan to w. By default it isn't printed.
+func WithPlanOutput(w io.Writer) Option {
+	return func(s *Syncer) {
+		s.planOutput = w
+	}
+}
+
+// WithLogger logs the steps to logger, rather than the standard logger
+func WithLogger(logger *log.Entry) Option {
+	return func(s *Syncer) {
+		s.logger = logger
+	}
+}
+
+// WithStepTimeout limits how long each step can take, rather than
+// DefaultStepTimeout. Zero means no limit.
+func WithStepTimeout(timeout time.Duration) Option {
+	return func(s *Syncer) {
+		s.stepTimeout = timeout
+	}
+}
+
+// WithSyncTimeout limits how long a whole run can take, rather than
+// DefaultSyncTimeout. Zero means no limit.
+func WithSyncTimeout(timeout time.Duration) Option {
+	return func(s *Syncer) {
+		s.syncTimeout = timeout
+	}
+}
+
+// NewSyncer returns a Syncer configured by opts. It returns an error if the
+// options are incomplete, or name steps which don't exist.
+func NewSyncer(opts ...Option) (*Syncer, error) {
+	s := &Syncer{
+		steps:       syncSteps,
+		planOutput:  ioutil.Discard,
+		logger:      log.NewEntry(log.StandardLogger()),
+		stepTimeout: DefaultStepTimeout,
+		syncTimeout: DefaultSyncTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.config == nil {
+		return nil, errors.New("no config, expected WithConfig")
+	}
+	if len(s.targets) == 0 {
+		return nil, errors.New("no pachd to apply the config to, expected WithClient or WithTargets")
+	}
+	for _, t := range s.targets {
+		if t.Client == nil {
+			return nil, fmt.Errorf("target %q has no client", t.Name)
+		}
+	}
+	if s.ec == nil {
+		if len(s.targets) > 1 {
+			return nil, errors.New("no enterprise server for multiple targets, expected WithEnterpriseClient")
+		}
+		s.ec = s.targets[0].Client
+	}
+
+	var err error
+	if s.excluded, err = excludeSteps(s.only, s.skip); err != nil {
+		return nil, err
+	}
+	// log in the order of syncSteps, rather than map order
+	deps := excludedDeps(s.excluded)
+	for _, step := range syncSteps {
+		if len(deps[step.name]) > 0 {
+			s.logger.WithField("step", step.name).WithField("excluded", deps[step.name]).Info("dependencies excluded by --only or --skip are assumed to be applied already")
+		}
+	}
+	return s, nil
+}
+
+// ValidateSteps returns the error NewSyncer would for the steps passed to
+// WithOnly and WithSkip, so that they can be checked before connecting to pachd
+func ValidateSteps(only, skip []string) error {
+	_, err := excludeSteps(only, skip)
+	return err
+}
+
+// excludeSteps returns the names of the steps which --only and --skip
+// exclude from a run. Naming a step which doesn't exist, or in both lists, is
+// an error, since a typo would otherwise silently run or skip the wrong steps.
+func excludeSteps(only, skip []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	names := make([]string, 0, len(syncSteps))
+	for _, step := range syncSteps {
+		known[step.name] = true
+		names = append(names, strconv.Quote(step.name))
+	}
+	for _, name := range append(append([]string{}, only...), skip...) {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown step %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	for _, name := range only {
+		for _, skipped := range skip {
+			if name == skipped {
+				return nil, fmt.Errorf("step %q is both in --only and --skip", name)
+			}
+		}
+	}
+
+	excluded := make(map[string]bool)
+	if len(only) > 0 {
+		for name := range known {
+			excluded[name] = true
+		}
+		for _, name := range only {
+			delete(excluded, name)
+		}
+	}
+	for _, name := range skip {
+		excluded[name] = true
+	}
+	return excluded, nil
+}
+
+// excludedDeps returns the excluded dependencies of each step which isn't
+// excluded. These steps run as if their dependencies had been skipped, so
+// they assume the dependencies were already applied by an earlier run.
+func excludedDeps(excluded map[string]bool) map[string][]string {
+	result := make(map[string][]string)
+	for _, step := range syncSteps {
+		if excluded[step.name] {
+			continue
+		}
+		for _, dep := range step.deps {
+			if excluded[dep] {
+				result[step.name] = append(result[step.name], dep)
+			}
+		}
+	}
+	return result
+}
+
+// withTimeout is like context.WithTimeout, except a zero timeout means none
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
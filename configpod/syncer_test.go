@@ -0,0 +1,70 @@
+package configpod
+
+import (
+	"testing"
+
+	"github.com/pachyderm/pachyderm/v2/src/client"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSyncer(t *testing.T) {
+	c := &client.APIClient{}
+	config := NewConfig("")
+
+	s, err := NewSyncer(WithConfig(config), WithClient(c))
+	require.NoError(t, err)
+	require.Equal(t, c, s.ec)
+
+	_, err = NewSyncer(WithClient(c))
+	require.EqualError(t, err, "no config, expected WithConfig")
+
+	_, err = NewSyncer(WithConfig(config))
+	require.EqualError(t, err, "no pachd to apply the config to, expected WithClient or WithTargets")
+
+	_, err = NewSyncer(WithConfig(config), WithTargets(&Target{Name: "east"}))
+	require.EqualError(t, err, `target "east" has no client`)
+
+	_, err = NewSyncer(WithConfig(config), WithTargets(&Target{Name: "east", Client: c}, &Target{Name: "west", Client: c}))
+	require.EqualError(t, err, "no enterprise server for multiple targets, expected WithEnterpriseClient")
+
+	_, err = NewSyncer(WithConfig(config), WithClient(c), WithOnly("sync repo"))
+	require.Error(t, err)
+}
+
+func TestExcludeSteps(t *testing.T) {
+	excluded, err := excludeSteps(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(excluded))
+
+	excluded, err = excludeSteps(nil, []string{"sync repos", "sync pipelines"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"sync repos": true, "sync pipelines": true}, excluded)
+
+	excluded, err = excludeSteps([]string{"sync repos"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(syncSteps)-1, len(excluded))
+	require.False(t, excluded["sync repos"])
+
+	_, err = excludeSteps([]string{"sync repo"}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unknown step "sync repo", expected one of "license key", "enterprise secret", `)
+
+	_, err = excludeSteps([]string{"sync repos"}, []string{"sync repos"})
+	require.EqualError(t, err, `step "sync repos" is both in --only and --skip`)
+}
+
+func TestExcludedDeps(t *testing.T) {
+	excluded, err := excludeSteps([]string{"sync cluster role bindings"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"sync cluster role bindings": {"activate authentication"},
+	}, excludedDeps(excluded))
+
+	excluded, err = excludeSteps(nil, []string{"sync repos"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"sync repo role bindings": {"sync repos"},
+		"sync pipelines":          {"sync repos"},
+	}, excludedDeps(excluded))
+}
@@ -0,0 +1,121 @@
+package configpod
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pachyderm/pachyderm/v2/src/client"
+)
+
+// targetsPath is the config key listing the pachds to configure, when
+// there's more than one
+const targetsPath = "targets"
+
+// stepScope is which pachds a step is applied to
+type stepScope int
+
+const (
+	// enterpriseScope steps configure the enterprise server, so they run once
+	enterpriseScope stepScope = iota
+	// clusterScope steps configure a pachd, so they run once for every target
+	clusterScope
+)
+
+// Target is a pachd which the cluster-scoped steps are applied to. Without
+// the targets key there's a single target, usually the pachd at --pach-addr,
+// which has no name and reads all of its config from the config root.
+type Target struct {
+	// Name identifies the target in logs and the run result
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// RootToken authenticates with the target, and is the root token its
+	// auth is activated with, in place of the rootToken key
+	RootToken string `json:"rootToken"`
+	// Overrides replace config keys for this target, such as its own
+	// enterpriseConfig, with the contents the key's file would have
+	Overrides map[string]string `json:"overrides"`
+
+	// Client is connected to the target, authenticated with its root token
+	Client *client.APIClient `json:"-"`
+}
+
+// overridableKeys are the config keys read by cluster-scoped steps, which a
+// target can override
+var overridableKeys = map[string]bool{
+	enterpriseConfigPath:      true,
+	identityServiceConfigPath: true,
+	authConfigPath:            true,
+	reposPath:                 true,
+	clusterRoleBindingsPath:   true,
+	repoRoleBindingsPath:      true,
+}
+
+// Targets loads the targets key, or returns an ErrSkipped if it isn't set.
+// The targets aren't connected to, so their Clients are nil.
+func (c *Config) Targets(ctx context.Context) ([]*Target, error) {
+	var targets []*Target
+	if err := c.loadYAML(ctx, targetsPath, &targets); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets listed", targetsPath)
+	}
+	return targets, nil
+}
+
+// name returns the target's name, which is empty for enterprise-scoped steps
+// and the default target
+func (t *Target) name() string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+// label describes the step running against a target, for logs and plans
+func (t *Target) label(stepName string) string {
+	if t.name() == "" {
+		return stepName
+	}
+	return fmt.Sprintf("%s [%s]", stepName, t.Name)
+}
+
+// override returns the target's contents for a config key, if it overrides it
+func (t *Target) override(path string) ([]byte, bool) {
+	if t == nil {
+		return nil, false
+	}
+	if path == rootTokenPath && t.RootToken != "" {
+		return []byte(t.RootToken), true
+	}
+	v, ok := t.Overrides[path]
+	return []byte(v), ok
+}
+
+// loadKey loads a config key for the step's target, which is the target's
+// override or else the config root's file, or returns an ErrSkipped if
+// neither exists
+func (s *stepState) loadKey(path string) ([]byte, error) {
+	if data, ok := s.target.override(path); ok {
+		return data, nil
+	}
+	return s.config.skipIfNotExist(path)
+}
+
+// loadResolvable is like loadKey, for a value which may be a secret reference
+func (s *stepState) loadResolvable(ctx context.Context, path string) ([]byte, error) {
+	data, err := s.loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.config.resolveData(ctx, data)
+}
+
+// loadYAML is like Config.loadYAML, for the step's target
+func (s *stepState) loadYAML(ctx context.Context, path string, out interface{}) error {
+	data, ok := s.target.override(path)
+	if !ok {
+		return s.config.loadYAML(ctx, path, out)
+	}
+	return s.config.parseYAML(ctx, fmt.Sprintf("%s: %s: overrides.%s", targetsPath, s.target.Name, path), data, out)
+}
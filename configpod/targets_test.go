@@ -0,0 +1,95 @@
+package configpod
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+	"github.com/pachyderm/pachyderm/v2/src/license"
+
+	"github.com/pachyderm/config-pod/testpachd"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTargets tests that cluster-scoped steps are applied to every target,
+// with its overrides, and enterprise-scoped steps only once
+func TestTargets(t *testing.T) {
+	root, err := ioutil.TempDir("", "targets")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	config := NewConfig(root)
+	write := func(path, data string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, path), []byte(data), 0644))
+	}
+
+	os.Setenv("WEST_ROOT_TOKEN", "westtoken")
+	defer os.Unsetenv("WEST_ROOT_TOKEN")
+	write(targetsPath, `
+- name: east
+  address: grpc://pachd-east:30653
+  rootToken: easttoken
+- name: west
+  address: grpc://pachd-west:30653
+  rootToken: env:WEST_ROOT_TOKEN
+  overrides:
+    clusterRoleBindings: |
+      robot:west: [repoWriter]
+`)
+	write(licensePath, "test-activation-code")
+	write(clusterRoleBindingsPath, "robot:test: [repoReader]\n")
+	write(reposPath, "- name: images\n")
+
+	targets, err := config.Targets(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(targets))
+	require.Equal(t, "westtoken", targets[1].RootToken)
+
+	enterpriseServer, err := testpachd.New()
+	require.NoError(t, err)
+	defer enterpriseServer.Close()
+	for _, target := range targets {
+		pachd, err := testpachd.New()
+		require.NoError(t, err)
+		defer pachd.Close()
+		target.Client = pachd.Client()
+		target.Client.SetAuthToken(target.RootToken)
+
+		// the in-memory pachd is its own license server, so it needs a
+		// license to activate auth
+		_, err = target.Client.License.Activate(target.Client.Ctx(), &license.ActivateRequest{ActivationCode: "test-activation-code"})
+		require.NoError(t, err)
+	}
+
+	syncer, err := NewSyncer(WithConfig(config), WithTargets(targets...), WithEnterpriseClient(enterpriseServer.Client()))
+	require.NoError(t, err)
+	report, err := syncer.Run(context.Background())
+	require.NoError(t, err)
+
+	ran := make(map[string][]string)
+	for _, step := range report.Steps {
+		ran[step.Name] = append(ran[step.Name], step.Target)
+	}
+	require.Equal(t, []string{""}, ran["license key"])
+	require.Equal(t, []string{"east", "west"}, ran["sync repos"])
+
+	bindings := make(map[string][]string)
+	for _, target := range targets {
+		resp, err := target.Client.GetRoleBinding(target.Client.Ctx(), &auth.GetRoleBindingRequest{
+			Resource: &auth.Resource{Type: auth.ResourceType_CLUSTER},
+		})
+		require.NoError(t, err)
+		for p := range resp.Binding.Entries {
+			bindings[target.Name] = append(bindings[target.Name], p)
+		}
+
+		repos, err := listRepos(target.Client)
+		require.NoError(t, err)
+		require.Contains(t, repos, "images")
+	}
+	require.ElementsMatch(t, []string{"pach:root", "robot:test"}, bindings["east"])
+	require.ElementsMatch(t, []string{"pach:root", "robot:west"}, bindings["west"])
+}
@@ -0,0 +1,521 @@
+package configpod
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+	"github.com/pachyderm/pachyderm/v2/src/enterprise"
+	"github.com/pachyderm/pachyderm/v2/src/identity"
+	"github.com/pachyderm/pachyderm/v2/src/license"
+
+	"github.com/ghodss/yaml"
+)
+
+// referenceChecks check the syntax of secret references by scheme, without
+// resolving them. References to schemes without a check are always valid.
+var referenceChecks = map[string]func(ref string) error{
+	"env": func(name string) error {
+		if !envVarName.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		return nil
+	},
+	"file": func(path string) error {
+		if path == "" {
+			return errors.New("no path")
+		}
+		return nil
+	},
+	"base64": func(encoded string) error {
+		_, err := base64.StdEncoding.DecodeString(encoded)
+		return err
+	},
+	"exec": func(command string) error {
+		if len(strings.Fields(command)) == 0 {
+			return errors.New("no command")
+		}
+		return nil
+	},
+}
+
+// registerReferenceCheck adds a syntax check for a secret reference scheme
+func registerReferenceCheck(scheme string, check func(ref string) error) {
+	referenceChecks[scheme] = check
+}
+
+// principalPrefixes are the kinds of principal which can be given roles
+var principalPrefixes = []string{
+	auth.UserPrefix,
+	auth.RobotPrefix,
+	auth.GroupPrefix,
+	auth.PipelinePrefix,
+	auth.PachPrefix,
+}
+
+var validRoles = map[string]bool{
+	auth.ClusterAdminRole:   true,
+	auth.RepoOwnerRole:      true,
+	auth.RepoWriterRole:     true,
+	auth.RepoReaderRole:     true,
+	auth.OIDCAppAdminRole:   true,
+	auth.IDPAdminRole:       true,
+	auth.IdentityAdminRole:  true,
+	auth.DebuggerRole:       true,
+	auth.RobotUserRole:      true,
+	auth.LicenseAdminRole:   true,
+	auth.SecretAdminRole:    true,
+	auth.PachdLogReaderRole: true,
+}
+
+var repoName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ConfigError is a problem with a config file, or with a field within it
+type ConfigError struct {
+	// File is the config key, or the config root if it can't be read
+	File string
+	// Field is the path of the field within the file, if the problem is with one
+	Field   string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Field, e.Message)
+}
+
+// configValidator collects every problem with a config root, rather than
+// stopping at the first one like the sync steps do
+type configValidator struct {
+	config *Config
+	errs   []ConfigError
+}
+
+func (v *configValidator) errorf(file, field, format string, args ...interface{}) {
+	v.errs = append(v.errs, ConfigError{File: file, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks every config file in the config root without connecting
+// to pachd or resolving secret references, and returns the problems it finds
+func (c *Config) Validate() []ConfigError {
+	v := &configValidator{config: c}
+	info, err := os.Stat(c.root)
+	if c.root == "" {
+		info, err = fs.Stat(c.fsys, ".")
+	}
+	if err != nil {
+		v.errorf(c.root, "", "%v", err)
+		return v.errs
+	} else if !info.IsDir() {
+		v.errorf(c.root, "", "not a directory")
+		return v.errs
+	}
+
+	for _, path := range []string{rootTokenPath, licensePath, enterpriseSecretPath} {
+		v.value(path)
+	}
+	if addr, ok := v.value(enterpriseServerAddress); ok {
+		v.address(enterpriseServerAddress, "", addr)
+		if _, ok := v.value(enterpriseRootTokenPath); !ok {
+			v.errorf(enterpriseRootTokenPath, "", "required when %s is set", enterpriseServerAddress)
+		}
+	}
+
+	v.enterpriseClusters()
+	v.enterpriseConfig()
+	v.oidcClients()
+	v.idps()
+	v.authConfig()
+	v.identityServiceConfig()
+	v.roleBindings()
+	v.repos()
+	v.targets()
+
+	var prune pruneConfig
+	v.decode(prunePath, &prune)
+
+	if _, err := c.loadPipelineSpecs(); err != nil && !errors.Is(err, ErrSkipped) {
+		v.errorf(pipelinesPath, "", "%v", err)
+	}
+	return v.errs
+}
+
+// value loads a config file containing a single value, which may be a secret
+// reference, and returns false if it's missing or invalid
+func (v *configValidator) value(path string) (string, bool) {
+	data, err := v.config.skipIfNotExist(path)
+	if err != nil {
+		if !errors.Is(err, ErrSkipped) {
+			v.errorf(path, "", "%v", err)
+		}
+		return "", false
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		v.errorf(path, "", "empty")
+		return "", false
+	}
+	if err := checkReference(value); err != nil {
+		v.errorf(path, "", "%v", err)
+		return "", false
+	}
+	return value, true
+}
+
+// decode loads a YAML config file into target like loadYAML, except that
+// unknown fields are errors and secret references are checked rather than
+// resolved. It returns false if the file is missing or couldn't be decoded,
+// or if its whole content is a secret reference, which is only checked.
+func (v *configValidator) decode(path string, target interface{}) bool {
+	data, err := v.config.skipIfNotExist(path)
+	if err != nil {
+		if !errors.Is(err, ErrSkipped) {
+			v.errorf(path, "", "%v", err)
+		}
+		return false
+	}
+	if value := strings.TrimRight(string(data), "\r\n"); isReference(value) {
+		if err := checkReference(value); err != nil {
+			v.errorf(path, "", "%v", err)
+		}
+		return false
+	}
+
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		v.errorf(path, "", "%v", err)
+		return false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		v.errorf(path, "", "%v", err)
+		return false
+	}
+
+	// walk the raw document, so errors name fields as they're written
+	var raw interface{}
+	if err := json.Unmarshal(jsonData, &raw); err != nil {
+		v.errorf(path, "", "%v", err)
+		return false
+	}
+	v.references(path, "", raw)
+	return true
+}
+
+// references checks the syntax of every secret reference in a decoded document
+func (v *configValidator) references(file, field string, raw interface{}) {
+	switch raw := raw.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(raw))
+		for k := range raw {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v.references(file, joinFieldPath(field, k), raw[k])
+		}
+	case []interface{}:
+		for i, elem := range raw {
+			v.references(file, fmt.Sprintf("%s[%d]", field, i), elem)
+		}
+	case string:
+		if err := checkReference(raw); err != nil {
+			v.errorf(file, field, "%v", err)
+		}
+	}
+}
+
+// checkReference checks the syntax of a value which may be a secret
+// reference, as understood by resolveValue
+func checkReference(value string) error {
+	if strings.HasPrefix(value, "$") {
+		return referenceChecks["env"](strings.TrimPrefix(value, "$"))
+	}
+	scheme, ref, ok := splitReference(value)
+	if !ok {
+		return nil
+	}
+	if check, ok := referenceChecks[scheme]; ok {
+		if err := check(ref); err != nil {
+			return fmt.Errorf("invalid %s reference: %w", scheme, err)
+		}
+	}
+	return nil
+}
+
+// isReference returns true if a value is resolved from a secret, in which
+// case its format can't be checked offline
+func isReference(value string) bool {
+	_, _, ok := splitReference(value)
+	return ok || strings.HasPrefix(value, "$")
+}
+
+func (v *configValidator) required(file, field, value string) bool {
+	if value == "" {
+		v.errorf(file, field, "required")
+		return false
+	}
+	return true
+}
+
+// address checks a pachd address, in the formats accepted by the pachyderm client
+func (v *configValidator) address(file, field, value string) {
+	if value == "" || isReference(value) {
+		return
+	}
+	if !strings.Contains(value, "://") {
+		value = "grpc://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.errorf(file, field, "invalid address: %v", err)
+		return
+	}
+	switch u.Scheme {
+	case "grpc", "grpcs", "http", "https", "unix":
+	default:
+		v.errorf(file, field, "invalid address %q: unrecognized scheme %q", value, u.Scheme)
+		return
+	}
+	if u.Scheme != "unix" && (u.Path != "" || u.User != nil || u.RawQuery != "" || u.Fragment != "") {
+		v.errorf(file, field, "invalid address %q: should only include a scheme, host and port", value)
+	}
+}
+
+// url checks an HTTP URL, such as an OIDC issuer or redirect URI
+func (v *configValidator) url(file, field, value string) {
+	if value == "" || isReference(value) {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.errorf(file, field, "invalid URL: %v", err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.errorf(file, field, "invalid URL %q: expected http:// or https://", value)
+	}
+}
+
+// unique checks that an ID isn't repeated
+func (v *configValidator) unique(file, field, id string, seen map[string]bool) {
+	if id != "" && seen[id] {
+		v.errorf(file, field, "duplicate %q", id)
+	}
+	seen[id] = true
+}
+
+func (v *configValidator) enterpriseClusters() {
+	var clusters []license.AddClusterRequest
+	if !v.decode(enterpriseClustersPath, &clusters) {
+		return
+	}
+	seen := make(map[string]bool)
+	for i, c := range clusters {
+		field := fmt.Sprintf("[%d]", i)
+		if v.required(enterpriseClustersPath, field+".id", c.Id) {
+			v.unique(enterpriseClustersPath, field+".id", c.Id, seen)
+		}
+		if v.required(enterpriseClustersPath, field+".address", c.Address) {
+			v.address(enterpriseClustersPath, field+".address", c.Address)
+		}
+		v.address(enterpriseClustersPath, field+".user_address", c.UserAddress)
+	}
+}
+
+func (v *configValidator) enterpriseConfig() {
+	var config enterprise.ActivateRequest
+	if !v.decode(enterpriseConfigPath, &config) {
+		return
+	}
+	v.required(enterpriseConfigPath, "id", config.Id)
+	v.required(enterpriseConfigPath, "secret", config.Secret)
+	if v.required(enterpriseConfigPath, "license_server", config.LicenseServer) {
+		v.address(enterpriseConfigPath, "license_server", config.LicenseServer)
+	}
+}
+
+func (v *configValidator) oidcClients() {
+	var clients []identity.OIDCClient
+	if !v.decode(oidcClientsPath, &clients) {
+		return
+	}
+	seen := make(map[string]bool)
+	for i, c := range clients {
+		field := fmt.Sprintf("[%d]", i)
+		if v.required(oidcClientsPath, field+".id", c.Id) {
+			v.unique(oidcClientsPath, field+".id", c.Id, seen)
+		}
+		v.required(oidcClientsPath, field+".name", c.Name)
+		for j, uri := range c.RedirectUris {
+			v.url(oidcClientsPath, fmt.Sprintf("%s.redirect_uris[%d]", field, j), uri)
+		}
+	}
+}
+
+func (v *configValidator) idps() {
+	var connectors []identity.IDPConnector
+	if !v.decode(idpsPath, &connectors) {
+		return
+	}
+	seen := make(map[string]bool)
+	for i, c := range connectors {
+		field := fmt.Sprintf("[%d]", i)
+		if v.required(idpsPath, field+".id", c.Id) {
+			v.unique(idpsPath, field+".id", c.Id, seen)
+		}
+		v.required(idpsPath, field+".name", c.Name)
+		v.required(idpsPath, field+".type", c.Type)
+		if v.required(idpsPath, field+".jsonConfig", c.JsonConfig) && !isReference(c.JsonConfig) {
+			var config map[string]interface{}
+			if err := json.Unmarshal([]byte(c.JsonConfig), &config); err != nil {
+				v.errorf(idpsPath, field+".jsonConfig", "invalid JSON object: %v", err)
+			}
+		}
+	}
+}
+
+func (v *configValidator) authConfig() {
+	var config auth.OIDCConfig
+	if !v.decode(authConfigPath, &config) {
+		return
+	}
+	if v.required(authConfigPath, "issuer", config.Issuer) {
+		v.url(authConfigPath, "issuer", config.Issuer)
+	}
+	v.required(authConfigPath, "client_id", config.ClientID)
+	v.url(authConfigPath, "redirect_uri", config.RedirectURI)
+}
+
+func (v *configValidator) identityServiceConfig() {
+	var config identity.IdentityServerConfig
+	if !v.decode(identityServiceConfigPath, &config) {
+		return
+	}
+	if v.required(identityServiceConfigPath, "issuer", config.Issuer) {
+		v.url(identityServiceConfigPath, "issuer", config.Issuer)
+	}
+}
+
+// binding checks the principals and roles of a role binding
+func (v *configValidator) binding(file, field string, binding map[string][]string) {
+	principals := make([]string, 0, len(binding))
+	for p := range binding {
+		principals = append(principals, p)
+	}
+	sort.Strings(principals)
+
+	for _, p := range principals {
+		if !validPrincipal(p) {
+			v.errorf(file, joinFieldPath(field, p), "invalid principal, expected one of %s or %s",
+				strings.Join(principalPrefixes, ", "), auth.AllClusterUsersSubject)
+		}
+		for _, role := range binding[p] {
+			if !validRoles[role] {
+				v.errorf(file, joinFieldPath(field, p), "unknown role %q", role)
+			}
+		}
+	}
+}
+
+func validPrincipal(principal string) bool {
+	if principal == auth.AllClusterUsersSubject {
+		return true
+	}
+	for _, prefix := range principalPrefixes {
+		if strings.HasPrefix(principal, prefix) && len(principal) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *configValidator) roleBindings() {
+	var clusterRoleBindings map[string][]string
+	if v.decode(clusterRoleBindingsPath, &clusterRoleBindings) {
+		v.binding(clusterRoleBindingsPath, "", clusterRoleBindings)
+	}
+
+	var repoRoleBindings map[string]map[string][]string
+	if v.decode(repoRoleBindingsPath, &repoRoleBindings) {
+		repos := make([]string, 0, len(repoRoleBindings))
+		for repo := range repoRoleBindings {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+		for _, repo := range repos {
+			if !repoName.MatchString(repo) {
+				v.errorf(repoRoleBindingsPath, repo, "invalid repo name")
+			}
+			v.binding(repoRoleBindingsPath, repo, repoRoleBindings[repo])
+		}
+	}
+}
+
+func (v *configValidator) repos() {
+	var repos []repoConfig
+	if !v.decode(reposPath, &repos) {
+		return
+	}
+	seen := make(map[string]bool)
+	for i, r := range repos {
+		field := fmt.Sprintf("[%d].name", i)
+		if !v.required(reposPath, field, r.Name) {
+			continue
+		}
+		if !repoName.MatchString(r.Name) {
+			v.errorf(reposPath, field, "invalid repo name %q, expected only letters, numbers, _ and -", r.Name)
+		}
+		v.unique(reposPath, field, r.Name, seen)
+	}
+}
+
+func (v *configValidator) targets() {
+	var targets []Target
+	if !v.decode(targetsPath, &targets) {
+		return
+	}
+	if len(targets) == 0 {
+		v.errorf(targetsPath, "", "no targets listed")
+	}
+	seen := make(map[string]bool)
+	for i, t := range targets {
+		field := fmt.Sprintf("[%d]", i)
+		if v.required(targetsPath, field+".name", t.Name) {
+			v.unique(targetsPath, field+".name", t.Name, seen)
+		}
+		if v.required(targetsPath, field+".address", t.Address) {
+			v.address(targetsPath, field+".address", t.Address)
+		}
+		v.required(targetsPath, field+".rootToken", t.RootToken)
+
+		keys := make([]string, 0, len(t.Overrides))
+		for key := range t.Overrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			overrideField := fmt.Sprintf("%s.overrides.%s", field, key)
+			if !overridableKeys[key] {
+				v.errorf(targetsPath, overrideField, "can't be overridden, only keys read by cluster-scoped steps can")
+				continue
+			}
+			var out interface{}
+			if err := yaml.Unmarshal([]byte(t.Overrides[key]), &out); err != nil {
+				v.errorf(targetsPath, overrideField, "%v", err)
+			}
+		}
+	}
+}
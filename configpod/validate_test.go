@@ -0,0 +1,95 @@
+package configpod
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "validate")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	write := func(path, data string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, path), []byte(data), 0644))
+	}
+	write(rootTokenPath, "vault:secret/data/pachyderm\n")
+	write(enterpriseServerAddress, "grpc://enterprise:1650")
+	write(enterpriseClustersPath, `
+- id: localhost
+  address: grpc://localhost:1650
+  secret: env:ENTERPRISE_SECRET
+- id: localhost
+  address: ftp://remote
+- address: grpc://remote:1650/path
+`)
+	write(oidcClientsPath, `
+- id: pachd
+  name: pachd
+  secret: base64:not base64
+  redirect_uris:
+  - localhost:30657/callback
+`)
+	write(idpsPath, `
+- id: test
+  name: test
+  type: mockPassword
+  jsonConfig: '{"username": "admin"'
+`)
+	write(authConfigPath, `
+issuer: http://pachd:1658/
+client_id: pachd
+client_secret: $CLIENT_SECRET
+redirect_uri: http://localhost:30657/authorization-code/callback
+`)
+	write(identityServiceConfigPath, "isuer: http://pachd:1658/\n")
+	write(clusterRoleBindingsPath, `
+robot:test: [repoReader]
+test: [clusterAdmin]
+allClusterUsers: [repoOwner, repoAdmin]
+`)
+	write(repoRoleBindingsPath, `
+images/raw:
+  user:alice@example.com: [repoWriter]
+`)
+	write(reposPath, "- name: images\n- description: no name\n")
+	write(targetsPath, `
+- name: east
+  address: grpc://pachd-east:30653
+  rootToken: env:EAST_ROOT_TOKEN
+  overrides:
+    license: test
+    authConfig: "issuer: [unclosed"
+- name: east
+  address: pachd-west:30653
+`)
+
+	var msgs []string
+	for _, err := range NewConfig(root).Validate() {
+		msgs = append(msgs, err.Error())
+	}
+	require.Equal(t, []string{
+		`rootToken: invalid vault reference: expected path#field, got "secret/data/pachyderm"`,
+		"enterpriseRootToken: required when enterpriseServerAddress is set",
+		`enterpriseClusters: [1].id: duplicate "localhost"`,
+		`enterpriseClusters: [1].address: invalid address "ftp://remote": unrecognized scheme "ftp"`,
+		"enterpriseClusters: [2].id: required",
+		`enterpriseClusters: [2].address: invalid address "grpc://remote:1650/path": should only include a scheme, host and port`,
+		"oidcClients: [0].secret: invalid base64 reference: illegal base64 data at input byte 3",
+		`oidcClients: [0].redirect_uris[0]: invalid URL "localhost:30657/callback": expected http:// or https://`,
+		"idps: [0].jsonConfig: invalid JSON object: unexpected end of JSON input",
+		`identityServiceConfig: json: unknown field "isuer"`,
+		"clusterRoleBindings: allClusterUsers: unknown role \"repoAdmin\"",
+		"clusterRoleBindings: test: invalid principal, expected one of user:, robot:, group:, pipeline:, pach: or allClusterUsers",
+		"repoRoleBindings: images/raw: invalid repo name",
+		"repos: [1].name: required",
+		"targets: [0].overrides.authConfig: error converting YAML to JSON: yaml: line 1: did not find expected ',' or ']'",
+		"targets: [0].overrides.license: can't be overridden, only keys read by cluster-scoped steps can",
+		`targets: [1].name: duplicate "east"`,
+		"targets: [1].rootToken: required",
+	}, msgs)
+}
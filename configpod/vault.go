@@ -0,0 +1,203 @@
+package configpod
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultVaultK8sTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func init() {
+	registerResolver("vault", resolveVault)
+	registerReferenceCheck("vault", func(ref string) error {
+		_, _, err := splitVaultReference(ref)
+		return err
+	})
+}
+
+// vaultClient reads secrets from the HashiCorp Vault HTTP API. It's
+// configured with the same environment variables as the Vault CLI:
+//
+//	VAULT_ADDR            the address of the Vault server (required)
+//	VAULT_TOKEN           a token to authenticate with, or else
+//	VAULT_K8S_ROLE        the role to log in as with the Kubernetes auth method
+//	VAULT_K8S_MOUNT       the mount path of the Kubernetes auth method (default "kubernetes")
+//	VAULT_K8S_TOKEN_PATH  the service account token to log in with
+//	VAULT_NAMESPACE       the Vault Enterprise namespace, if any
+type vaultClient struct {
+	addr       string
+	namespace  string
+	token      string
+	httpClient *http.Client
+}
+
+func newVaultClientFromEnv(ctx context.Context) (*vaultClient, error) {
+	v := &vaultClient{
+		addr:       strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"),
+		namespace:  os.Getenv("VAULT_NAMESPACE"),
+		token:      os.Getenv("VAULT_TOKEN"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	if v.addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+
+	if v.token == "" {
+		role := os.Getenv("VAULT_K8S_ROLE")
+		if role == "" {
+			return nil, fmt.Errorf("neither VAULT_TOKEN nor VAULT_K8S_ROLE is set")
+		}
+		if err := v.kubernetesLogin(ctx, role); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// vaultSession is the Vault client shared by every reference resolved in a
+// run, so that Kubernetes auth logs in once per run rather than per reference
+type vaultSession struct {
+	once   sync.Once
+	client *vaultClient
+	err    error
+}
+
+// vaultClient returns the run's Vault client, or a new one outside of a run.
+// The run's client logs in with ctx of the first reference resolved.
+func (c *Config) vaultClient(ctx context.Context) (*vaultClient, error) {
+	if c.vault == nil {
+		return newVaultClientFromEnv(ctx)
+	}
+	c.vault.once.Do(func() {
+		c.vault.client, c.vault.err = newVaultClientFromEnv(ctx)
+	})
+	return c.vault.client, c.vault.err
+}
+
+// kubernetesLogin exchanges the pod's service account token for a Vault token
+func (v *vaultClient) kubernetesLogin(ctx context.Context, role string) error {
+	mount := os.Getenv("VAULT_K8S_MOUNT")
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	tokenPath := os.Getenv("VAULT_K8S_TOKEN_PATH")
+	if tokenPath == "" {
+		tokenPath = defaultVaultK8sTokenPath
+	}
+
+	jwt, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return fmt.Errorf("could not read service account token: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Auth *struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+mount+"/login", body, &resp); err != nil {
+		return fmt.Errorf("kubernetes login failed: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("kubernetes login returned no token")
+	}
+	v.token = resp.Auth.ClientToken
+	return nil
+}
+
+// readKV reads a field of a KV version 2 secret. path is the full API path,
+// including the mount's "data/" segment, e.g. "secret/data/pachyderm".
+func (v *vaultClient) readKV(ctx context.Context, path, field string) (string, error) {
+	var resp struct {
+		Data *struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.Data == nil || resp.Data.Data == nil {
+		return "", fmt.Errorf("%s is not a KV version 2 secret", path)
+	}
+
+	value, ok := resp.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("%s has no field %q", path, field)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q of %s is not a string", field, path)
+	}
+	return s, nil
+}
+
+func (v *vaultClient) do(ctx context.Context, method, path string, body []byte, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+strings.TrimLeft(path, "/"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(vaultErr.Errors, "; "))
+		}
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	return json.Unmarshal(data, target)
+}
+
+// resolveVault resolves vault:path#field, e.g. vault:secret/data/pachyderm#rootToken,
+// to a field of a KV version 2 secret
+func resolveVault(ctx context.Context, c *Config, ref string) (string, error) {
+	path, field, err := splitVaultReference(ref)
+	if err != nil {
+		return "", err
+	}
+
+	v, err := c.vaultClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	return v.readKV(ctx, path, field)
+}
+
+// splitVaultReference splits path#field into the secret's path and field
+func splitVaultReference(ref string) (string, string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return "", "", fmt.Errorf("expected path#field, got %q", ref)
+	}
+	return ref[:i], ref[i+1:], nil
+}
@@ -0,0 +1,154 @@
+package configpod
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testVaultToken = "s.testtoken"
+	testVaultJWT   = "service-account-jwt"
+)
+
+// newTestVault starts a stand-in for the Vault API which serves a single KV
+// version 2 secret, and supports token and Kubernetes auth. It counts the
+// Kubernetes logins in logins, if it isn't nil.
+func newTestVault(t *testing.T, logins *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/kubernetes/login", func(w http.ResponseWriter, r *http.Request) {
+		if logins != nil {
+			atomic.AddInt32(logins, 1)
+		}
+		var req map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req["role"] != "config-pod" || req["jwt"] != testVaultJWT {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"auth": {"client_token": "`+testVaultToken+`"}}`)
+	})
+	mux.HandleFunc("/v1/secret/data/pachyderm", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != testVaultToken {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"data": {"rootToken": "vaultroottoken", "port": 1650}, "metadata": {"version": 1}}}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func setTestEnv(t *testing.T, env map[string]string) {
+	for k, v := range env {
+		require.NoError(t, os.Setenv(k, v))
+	}
+	t.Cleanup(func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	})
+}
+
+func TestVaultTokenAuth(t *testing.T) {
+	config := NewConfig("")
+	server := newTestVault(t, nil)
+	defer server.Close()
+	setTestEnv(t, map[string]string{"VAULT_ADDR": server.URL, "VAULT_TOKEN": testVaultToken})
+
+	v, err := config.resolveValue(context.Background(), "vault:secret/data/pachyderm#rootToken")
+	require.NoError(t, err)
+	require.Equal(t, "vaultroottoken", v)
+
+	_, err = config.resolveValue(context.Background(), "vault:secret/data/pachyderm#missing")
+	require.Error(t, err)
+
+	_, err = config.resolveValue(context.Background(), "vault:secret/data/pachyderm#port")
+	require.Error(t, err)
+
+	_, err = config.resolveValue(context.Background(), "vault:secret/data/other#rootToken")
+	require.Error(t, err)
+
+	_, err = config.resolveValue(context.Background(), "vault:secret/data/pachyderm")
+	require.Error(t, err)
+}
+
+// TestVaultCancelled tests that a Vault request is abandoned when the step
+// it's made for is cancelled
+func TestVaultCancelled(t *testing.T) {
+	config := NewConfig("")
+	server := newTestVault(t, nil)
+	defer server.Close()
+	setTestEnv(t, map[string]string{"VAULT_ADDR": server.URL, "VAULT_TOKEN": testVaultToken})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := config.resolveValue(ctx, "vault:secret/data/pachyderm#rootToken")
+	require.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestVaultKubernetesAuth(t *testing.T) {
+	config := NewConfig("")
+	server := newTestVault(t, nil)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vault")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenPath, []byte(testVaultJWT+"\n"), 0600))
+
+	setTestEnv(t, map[string]string{
+		"VAULT_ADDR":           server.URL,
+		"VAULT_K8S_ROLE":       "config-pod",
+		"VAULT_K8S_TOKEN_PATH": tokenPath,
+	})
+
+	v, err := config.resolveValue(context.Background(), "vault:secret/data/pachyderm#rootToken")
+	require.NoError(t, err)
+	require.Equal(t, "vaultroottoken", v)
+
+	require.NoError(t, os.Setenv("VAULT_K8S_ROLE", "other"))
+	_, err = config.resolveValue(context.Background(), "vault:secret/data/pachyderm#rootToken")
+	require.Error(t, err)
+}
+
+// TestVaultLoginOncePerRun tests that the references resolved in a run share
+// a single Kubernetes login
+func TestVaultLoginOncePerRun(t *testing.T) {
+	var logins int32
+	server := newTestVault(t, &logins)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vault")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenPath, []byte(testVaultJWT), 0600))
+	setTestEnv(t, map[string]string{
+		"VAULT_ADDR":           server.URL,
+		"VAULT_K8S_ROLE":       "config-pod",
+		"VAULT_K8S_TOKEN_PATH": tokenPath,
+	})
+
+	config := NewConfig("")
+	for _, run := range []*Config{config.forRun(), config.forRun()} {
+		for i := 0; i < 3; i++ {
+			v, err := run.resolveValue(context.Background(), "vault:secret/data/pachyderm#rootToken")
+			require.NoError(t, err)
+			require.Equal(t, "vaultroottoken", v)
+		}
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&logins))
+}
@@ -2,7 +2,7 @@
 
 set -euo pipefail
 
-docker build . -t pachyderm/config-pod:${CIRCLE_TAG}
+docker build . --build-arg VERSION=${CIRCLE_TAG} -t pachyderm/config-pod:${CIRCLE_TAG}
 
 echo "$DOCKERHUB_PASS" | docker login -u pachydermbuildbot --password-stdin
 docker push pachyderm/config-pod:${CIRCLE_TAG}
@@ -1,6 +1,6 @@
 module github.com/pachyderm/config-pod
 
-go 1.15
+go 1.16
 
 require (
 	github.com/ghodss/yaml v1.0.0
@@ -8,11 +8,17 @@ require (
 	github.com/jackc/pgx/v4 v4.13.0 // indirect
 	github.com/json-iterator/go v1.1.11 // indirect
 	github.com/pachyderm/pachyderm/v2 v2.0.5
+	// v1.11.0 is the version pachyderm requires; the replace below pins the
+	// version built to v1.5.0.
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e // indirect
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 	golang.org/x/tools v0.1.4 // indirect
+	// testpachd imports grpc directly. v1.38.0 is the version pachyderm requires;
+	// the replace below pins the version built to v1.29.1.
+	google.golang.org/grpc v1.38.0
 	gopkg.in/ini.v1 v1.62.0 // indirect
 	honnef.co/go/tools v0.1.4 // indirect
 )
@@ -1,109 +1,331 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/pachyderm/config-pod/configpod"
 	"github.com/pachyderm/pachyderm/v2/src/client"
+	pachversion "github.com/pachyderm/pachyderm/v2/src/version"
 
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	// These are the keys for the config secret
-	rootTokenPath             = "rootToken"
-	enterpriseRootTokenPath   = "enterpriseRootToken"
-	enterpriseServerAddress   = "enterpriseServerAddress"
-	licensePath               = "license"
-	enterpriseSecretPath      = "enterpriseSecret"
-	enterpriseClustersPath    = "enterpriseClusters"
-	enterpriseConfigPath      = "enterpriseConfig"
-	clusterRoleBindingsPath   = "clusterRoleBindings"
-	identityServiceConfigPath = "identityServiceConfig"
-	idpsPath                  = "idps"
-	oidcClientsPath           = "oidcClients"
-	authConfigPath            = "authConfig"
-)
-
-// the 1st client represents the pachd instance that needs to register with an enterprise server represented by the 2nd argument ("ec")
-// in the case of embedded servers, i.e. when the 'enterpriseServerAddress' path isn't populated, the two clients will be the same
-type clusterSyncFn func(c *client.APIClient, ec *client.APIClient) error
+func main() {
+	cmd, args := parseArgs(os.Args[1:])
+	switch cmd {
+	case "apply", "plan", "check":
+		// plan reports what each step would change without applying it, and
+		// check reports only what has drifted from the config
+		apply(cmd, args)
+	case "validate":
+		validate(args)
+	case "export":
+		export(args)
+	case "version":
+		fmt.Printf("config-pod %s (pachyderm client %s)\n", version, pachversion.PrettyPrintVersion(pachversion.Version))
+	case "help":
+		printUsage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
 
-type syncStep struct {
-	name string
-	fn   clusterSyncFn
+// parseFlags parses a command's flags, exiting if they're invalid
+func parseFlags(fs *flag.FlagSet, args []string, opts *options) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if err := setLogFormat(opts.logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 }
 
-var syncSteps = []syncStep{
-	syncStep{"license key", licenseStep},
-	syncStep{"enterprise secret", enterpriseSecretStep},
-	syncStep{"sync enterprise clusters", enterpriseClustersStep},
-	syncStep{"configure enterprise service", enterpriseConfigStep},
-	syncStep{"activate authentication", activateAuthStep},
-	syncStep{"configure identity service", identityServiceConfigStep},
-	syncStep{"sync oidc clients", oidcClientsStep},
-	syncStep{"configure auth", authConfigStep},
-	syncStep{"sync identity providers", idpsStep},
-	syncStep{"sync cluster role bindings", roleBindingsStep},
+// validate checks the config without connecting to pachd
+func validate(args []string) {
+	var opts options
+	fs := newFlagSet("validate", &opts, false)
+	parseFlags(fs, args, &opts)
+	root := opts.configRoot
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	errs := configpod.NewConfig(root).Validate()
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d problem(s) found\n", root, len(errs))
+		os.Exit(1)
+	}
+	fmt.Printf("%s: config is valid\n", root)
 }
 
-var (
-	configRoot string
-	pachAddr   string
-)
+// export writes the cluster's current state in the format of the config root
+func export(args []string) {
+	var opts options
+	fs := newFlagSet("export", &opts, true)
+	format := fs.String("format", "dir", "dir, to write config files, or secret, to write a Kubernetes Secret")
+	output := fs.String("output", "", "directory to write config files to, or file to write the Secret to (default stdout)")
+	secretName := fs.String("secret-name", "pachyderm-config", "name of the exported Secret")
+	redact := fs.Bool("redact", false, "replace secrets with "+configpod.RedactedSecret+" rather than environment variable references")
+	targetName := fs.String("target", "", "target to export, when the targets key lists more than one")
+	fs.StringVar(&opts.token, "token", os.Getenv("PACH_TOKEN"), "auth token to export with, rather than the config root's root tokens (PACH_TOKEN)")
+	parseFlags(fs, args, &opts)
 
-func main() {
-	configRoot = os.Getenv("PACH_CONFIG_ROOT")
-	if configRoot == "" {
-		configRoot = "/pachConfig"
+	if *format != "dir" && *format != "secret" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected dir or secret\n", *format)
+		os.Exit(2)
+	}
+	if *format == "dir" && *output == "" {
+		fmt.Fprintln(os.Stderr, "--output is required to export config files")
+		os.Exit(2)
 	}
 
-	pachAddr = os.Getenv("PACH_ADDR")
-	if pachAddr == "" {
-		pachAddr = "grpc://pachd-peer:30653"
+	targets, ec := connect(opts, configpod.NewConfig(opts.configRoot))
+	var t *configpod.Target
+	for _, candidate := range targets {
+		if candidate.Name == *targetName || (*targetName == "" && len(targets) == 1) {
+			t = candidate
+		}
+	}
+	if t == nil {
+		fmt.Fprintf(os.Stderr, "--target must name one of the %d targets\n", len(targets))
+		os.Exit(2)
 	}
 
-	log.WithField("addr", pachAddr).Infof("connecting to pachyderm")
-	c := connectToPach(pachAddr)
+	e := &configpod.Exporter{Redact: *redact}
+	files, err := e.Export(t.Client, ec)
+	if err != nil {
+		log.WithError(err).Error("failed to export cluster state")
+		os.Exit(1)
+	}
 
-	log.Infof("loading root auth token")
-	rootToken, err := loadRootToken()
+	switch {
+	case *format == "dir":
+		err = configpod.WriteConfigDir(*output, files)
+	case *output == "":
+		err = configpod.WriteSecretManifest(os.Stdout, *secretName, files)
+	default:
+		var f *os.File
+		if f, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err == nil {
+			err = configpod.WriteSecretManifest(f, *secretName, files)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
 	if err != nil {
-		if !errors.Is(err, errSkipped) {
-			log.WithError(err).Error("failed to load root auth token")
+		log.WithError(err).Error("failed to write export")
+		os.Exit(1)
+	}
+	if len(e.EnvVars) > 0 {
+		log.WithField("vars", e.EnvVars).Info("secrets were exported as references to environment variables, which must be set before applying the export")
+	}
+}
+
+// apply connects to pachd and runs the sync steps, once or in watch mode.
+// The plan and check commands run them without applying any changes.
+func apply(cmd string, args []string) {
+	var opts options
+	fs := newFlagSet(cmd, &opts, true)
+	addStepFlags(fs, &opts)
+	parseFlags(fs, args, &opts)
+
+	only, skip := splitList(opts.only), splitList(opts.skip)
+	if err := configpod.ValidateSteps(only, skip); err != nil {
+		log.WithError(err).Error("invalid --only or --skip")
+		os.Exit(2)
+	}
+	config := configpod.NewConfig(opts.configRoot)
+	syncOpts := []configpod.Option{configpod.WithConfig(config), configpod.WithOnly(only...), configpod.WithSkip(skip...)}
+	switch cmd {
+	case "plan":
+		syncOpts = append(syncOpts, configpod.WithDryRun(), configpod.WithPlanOutput(os.Stdout))
+	case "check":
+		// only the drift is printed, not the full plan
+		syncOpts = append(syncOpts, configpod.WithDryRun())
+	}
+
+	reportPath = os.Getenv("PACH_REPORT_PATH")
+	if path, ok := os.LookupEnv("PACH_TERMINATION_LOG"); ok {
+		terminationLogPath = path
+	}
+
+	if addr := os.Getenv("PACH_METRICS_ADDR"); addr != "" {
+		if err := serveMetrics(addr); err != nil {
+			log.WithError(err).Error("failed to start metrics server")
 			os.Exit(1)
 		}
-		log.WithField("reason", err).Info("not using auth token")
-	} else {
-		c.SetAuthToken(string(rootToken))
+		log.WithField("addr", addr).Info("serving metrics")
 	}
 
-	var ec *client.APIClient
-	enterpriseServerAddrBytes, err := loadEnterpriseServerAddress()
+	stepTimeout, err := durationFromEnv("PACH_STEP_TIMEOUT", configpod.DefaultStepTimeout)
 	if err != nil {
-		ec = c
-	} else {
-		ec = connectToPach(string(enterpriseServerAddrBytes))
-		enterpriseRootToken, err := loadEnterpriseRootToken()
+		log.WithError(err).Error("invalid PACH_STEP_TIMEOUT")
+		os.Exit(1)
+	}
+	syncTimeout, err := durationFromEnv("PACH_SYNC_TIMEOUT", configpod.DefaultSyncTimeout)
+	if err != nil {
+		log.WithError(err).Error("invalid PACH_SYNC_TIMEOUT")
+		os.Exit(1)
+	}
+	syncOpts = append(syncOpts, configpod.WithStepTimeout(stepTimeout), configpod.WithSyncTimeout(syncTimeout))
+
+	targets, ec := connect(opts, config)
+	syncer, err := configpod.NewSyncer(append(syncOpts, configpod.WithTargets(targets...), configpod.WithEnterpriseClient(ec))...)
+	if err != nil {
+		log.WithError(err).Error("failed to configure sync")
+		os.Exit(1)
+	}
+
+	// SIGTERM is sent when the Job's pod is deleted. Cancelling ctx stops the
+	// in-flight step before its next item, and the steps after it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	watch, err := boolFromEnv("PACH_CONFIG_WATCH")
+	if err != nil {
+		log.WithError(err).Error("invalid PACH_CONFIG_WATCH")
+		os.Exit(1)
+	}
+
+	if watch && cmd == "apply" {
+		pollInterval, err := durationFromEnv("PACH_CONFIG_POLL_INTERVAL", defaultPollInterval)
 		if err != nil {
-			log.WithError(err).Error("failed to load enterprise root auth token")
+			log.WithError(err).Error("invalid PACH_CONFIG_POLL_INTERVAL")
+			os.Exit(1)
+		}
+		resyncInterval, err := durationFromEnv("PACH_CONFIG_RESYNC_INTERVAL", defaultResyncInterval)
+		if err != nil {
+			log.WithError(err).Error("invalid PACH_CONFIG_RESYNC_INTERVAL")
 			os.Exit(1)
 		}
-		ec.SetAuthToken(string(enterpriseRootToken))
+		watchConfig(ctx, syncer, config.Root(), pollInterval, resyncInterval)
+		return
+	}
+
+	result, err := runSync(ctx, syncer)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	if cmd == "check" {
+		if code := checkDrift(os.Stdout, config.Root(), result); code != 0 {
+			os.Exit(code)
+		}
+	}
+}
+
+// runSync runs the sync steps, then records the result's metrics and saves its report
+func runSync(ctx context.Context, syncer *configpod.Syncer) (*configpod.Result, error) {
+	result, err := syncer.Run(ctx)
+	recordMetrics(result)
+	saveReport(result)
+	return result, err
+}
+
+// connect connects to the pachds the cluster-scoped steps are applied to,
+// and the enterprise server, if there is one, authenticated with the root
+// tokens from the config root, or with --token if it's set. The pachds are
+// those in the targets key, or else the one at --pach-addr. It exits if any
+// of them aren't ready in time.
+func connect(opts options, config *configpod.Config) ([]*configpod.Target, *client.APIClient) {
+	readyTimeout, err := durationFromEnv("PACH_READY_TIMEOUT", defaultReadyTimeout)
+	if err != nil {
+		log.WithError(err).Error("invalid PACH_READY_TIMEOUT")
+		os.Exit(1)
+	}
+	readyDeadline := time.Now().Add(readyTimeout)
+	// secret references in the targets and root tokens, such as exec:
+	// helpers, must resolve before the deadline too
+	ctx, cancel := context.WithDeadline(context.Background(), readyDeadline)
+	defer cancel()
+
+	targets, err := config.Targets(ctx)
+	if err != nil && !errors.Is(err, configpod.ErrSkipped) {
+		log.WithError(err).Error("failed to load targets")
+		os.Exit(1)
+	}
+
+	// the enterprise server defaults to the enterpriseServerAddress key
+	enterpriseAddr := opts.enterpriseAddr
+	if enterpriseAddr == "" {
+		if addr, err := config.EnterpriseServerAddress(); err == nil {
+			enterpriseAddr = addr
+		}
 	}
 
-	for _, step := range syncSteps {
-		stepLogger := log.WithField("step", step.name)
-		stepLogger.Info("running step")
-		err := step.fn(c, ec)
+	// with targets, the pachd at --pach-addr is only used as the enterprise
+	// server, when there isn't a separate one
+	var c *client.APIClient
+	if targets == nil || enterpriseAddr == "" {
+		log.WithField("addr", opts.pachAddr).Infof("connecting to pachyderm")
+		c, err = connectToPach(opts.pachAddr, readyDeadline)
+		if err != nil {
+			log.WithError(err).Error("failed to connect to pachyderm")
+			os.Exit(1)
+		}
+
+		log.Infof("loading root auth token")
+		rootToken, err := config.RootToken(ctx)
+		if opts.token != "" {
+			rootToken, err = opts.token, nil
+		}
 		if err != nil {
-			if !errors.Is(err, errSkipped) {
-				stepLogger.WithError(err).Error("error syncing cluster state")
+			if !errors.Is(err, configpod.ErrSkipped) {
+				log.WithError(err).Error("failed to load root auth token")
 				os.Exit(1)
 			}
-			stepLogger.WithField("reason", err).Warn("skipped")
+			log.WithField("reason", err).Info("not using auth token")
 		} else {
-			stepLogger.Info("success")
+			c.SetAuthToken(rootToken)
+		}
+	}
+
+	ec := c
+	if enterpriseAddr != "" {
+		log.WithField("addr", enterpriseAddr).Infof("connecting to enterprise server")
+		ec, err = connectToPach(enterpriseAddr, readyDeadline)
+		if err != nil {
+			log.WithError(err).Error("failed to connect to enterprise server")
+			os.Exit(1)
 		}
+		enterpriseRootToken, err := config.EnterpriseRootToken(ctx)
+		if opts.token != "" {
+			enterpriseRootToken, err = opts.token, nil
+		}
+		if err != nil {
+			log.WithError(err).Error("failed to load enterprise root auth token")
+			os.Exit(1)
+		}
+		ec.SetAuthToken(enterpriseRootToken)
+	}
+
+	if targets == nil {
+		return []*configpod.Target{{Client: c}}, ec
+	}
+	if opts.token != "" {
+		for _, t := range targets {
+			t.RootToken = opts.token
+		}
+	}
+	if err := connectTargets(targets, readyDeadline); err != nil {
+		log.WithError(err).Error("failed to connect to target")
+		os.Exit(1)
 	}
+	return targets, ec
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pachyderm/config-pod/configpod"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// Every metric is labelled with the name of the step, as listed in configpod.StepNames,
+// and the target it ran against, which is empty for enterprise-scoped steps
+// and when the targets key isn't set
+var (
+	stepLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "config_pod",
+		Name:      "step_last_success_timestamp_seconds",
+		Help:      "Unix time of the end of the last run in which the step succeeded.",
+	}, []string{"step", "target"})
+
+	stepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "config_pod",
+		Name:      "step_duration_seconds",
+		Help:      "How long the step took, by outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"step", "target", "outcome"})
+
+	stepItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "config_pod",
+		Name:      "step_items_total",
+		Help:      "Items the step applied, by action (create, update or delete).",
+	}, []string{"step", "target", "action"})
+
+	stepSkips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "config_pod",
+		Name:      "step_skips_total",
+		Help:      "Times the step was skipped, by reason.",
+	}, []string{"step", "target", "reason"})
+
+	stepFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "config_pod",
+		Name:      "step_failures_total",
+		Help:      "Times the step failed.",
+	}, []string{"step", "target"})
+
+	stepDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "config_pod",
+		Name:      "step_drift_items",
+		Help:      "Items which differed from the config on the step's last run, before it applied them.",
+	}, []string{"step", "target"})
+)
+
+func init() {
+	prometheus.MustRegister(stepLastSuccess, stepDuration, stepItems, stepSkips, stepFailures, stepDrift)
+}
+
+// serveMetrics serves the metrics at http://addr/metrics in the background
+func serveMetrics(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.WithError(http.Serve(listener, mux)).Error("metrics server stopped")
+	}()
+	return nil
+}
+
+// skipReasons map the start of a skipped step's reason to the value of the
+// reason label. The reason itself names the missing config file, so it isn't
+// used as the label, which would give every config root its own series.
+var skipReasons = []struct {
+	prefix, label string
+}{
+	{"no file ", "not configured"},
+	{"no directory ", "not configured"},
+	{"excluded by ", "excluded"},
+}
+
+// skipReason returns the reason label for a skipped step's reason
+func skipReason(reason string) string {
+	reason = strings.TrimPrefix(reason, configpod.ErrSkipped.Error()+" - ")
+	for _, r := range skipReasons {
+		if strings.HasPrefix(reason, r.prefix) {
+			return r.label
+		}
+	}
+	return "other"
+}
+
+// recordMetrics updates the metrics of each step which ran
+func recordMetrics(r *configpod.Result) {
+	for _, step := range r.Steps {
+		if step.Outcome == configpod.OutcomeNotRun {
+			continue
+		}
+		stepDuration.WithLabelValues(step.Name, step.Target, string(step.Outcome)).Observe(step.Duration)
+
+		switch step.Outcome {
+		case configpod.OutcomeApplied, configpod.OutcomePlanned:
+			stepLastSuccess.WithLabelValues(step.Name, step.Target).SetToCurrentTime()
+		case configpod.OutcomeSkipped:
+			stepSkips.WithLabelValues(step.Name, step.Target, skipReason(step.Reason)).Inc()
+		case configpod.OutcomeFailed:
+			stepFailures.WithLabelValues(step.Name, step.Target).Inc()
+		}
+
+		var drift int
+		for _, item := range step.Items {
+			if item.Drifted() {
+				drift++
+			}
+			if item.Applied {
+				stepItems.WithLabelValues(step.Name, step.Target, string(item.Action)).Inc()
+			}
+		}
+		stepDrift.WithLabelValues(step.Name, step.Target).Set(float64(drift))
+	}
+}
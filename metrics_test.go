@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pachyderm/config-pod/configpod"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordMetrics(t *testing.T) {
+	stepItems.Reset()
+	stepSkips.Reset()
+	stepFailures.Reset()
+	stepDrift.Reset()
+
+	recordMetrics(testReport())
+
+	require.Equal(t, 1.0, testutil.ToFloat64(stepSkips.WithLabelValues("license key", "", "not configured")))
+	require.Equal(t, 1.0, testutil.ToFloat64(stepFailures.WithLabelValues("sync repos", "")))
+	require.Equal(t, 1.0, testutil.ToFloat64(stepItems.WithLabelValues("sync repos", "", "create")))
+	require.Equal(t, 0.0, testutil.ToFloat64(stepItems.WithLabelValues("sync repos", "", "update")))
+	require.Equal(t, 2.0, testutil.ToFloat64(stepDrift.WithLabelValues("sync repos", "")))
+	require.Equal(t, 0.0, testutil.ToFloat64(stepDrift.WithLabelValues("license key", "")))
+
+	// steps which weren't run have no metrics
+	require.Equal(t, 2, testutil.CollectAndCount(stepDrift))
+
+	// the enterprise config can't be read back, so its update isn't drift
+	recordMetrics(&configpod.Result{DryRun: true, Steps: []configpod.StepResult{
+		{Name: "configure enterprise service", Outcome: configpod.OutcomePlanned, Items: []configpod.ItemResult{
+			{Kind: "enterprise config", ID: "localhost", Action: configpod.ActionUpdate},
+		}},
+	}})
+	require.Equal(t, 0.0, testutil.ToFloat64(stepDrift.WithLabelValues("configure enterprise service", "")))
+
+	// skip reasons are a fixed set, rather than naming the config file
+	recordMetrics(&configpod.Result{Steps: []configpod.StepResult{
+		{Name: "sync pipelines", Outcome: configpod.OutcomeSkipped, Reason: "skipped step - no directory /pachConfig/pipelines"},
+		{Name: "sync repos", Outcome: configpod.OutcomeSkipped, Reason: "skipped step - excluded by --only or --skip"},
+	}})
+	require.Equal(t, 1.0, testutil.ToFloat64(stepSkips.WithLabelValues("sync pipelines", "", "not configured")))
+	require.Equal(t, 1.0, testutil.ToFloat64(stepSkips.WithLabelValues("sync repos", "", "excluded")))
+	require.Equal(t, 3, testutil.CollectAndCount(stepSkips))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pachyderm/config-pod/configpod"
+	"github.com/pachyderm/pachyderm/v2/src/client"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultReadyTimeout is how long to wait for pachd to become ready, which
+// covers a fresh helm install where config-pod starts alongside pachd
+const defaultReadyTimeout = 5 * time.Minute
+
+// The delay between readiness checks starts at readyInitialBackoff and
+// doubles after every failed attempt, up to readyMaxBackoff
+var (
+	readyInitialBackoff = time.Second
+	readyMaxBackoff     = 30 * time.Second
+)
+
+// connectToPach connects to the pachd at addr and waits until it answers a
+// version request, retrying with exponential backoff until deadline
+func connectToPach(addr string, deadline time.Time) (*client.APIClient, error) {
+	var c *client.APIClient
+	err := retryUntilReady(log.WithField("addr", addr), deadline, func() error {
+		if c == nil {
+			// the client blocks until it's connected, so don't let it wait past the deadline
+			dialTimeout := time.Until(deadline)
+			if dialTimeout > client.DefaultDialTimeout {
+				dialTimeout = client.DefaultDialTimeout
+			}
+
+			var err error
+			if c, err = client.NewFromURI(addr, client.WithDialTimeout(dialTimeout)); err != nil {
+				c = nil
+				return fmt.Errorf("could not connect: %w", err)
+			}
+		}
+
+		// don't let the version request wait past the deadline either, in case
+		// pachd accepts connections but never answers
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		_, err := c.WithCtx(ctx).Version()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connectTargets connects to each target, authenticated with its root token
+func connectTargets(targets []*configpod.Target, readyDeadline time.Time) error {
+	for _, t := range targets {
+		log.WithField("target", t.Name).WithField("addr", t.Address).Info("connecting to target")
+		c, err := connectToPach(t.Address, readyDeadline)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		c.SetAuthToken(t.RootToken)
+		t.Client = c
+	}
+	return nil
+}
+
+// retryUntilReady calls check until it succeeds, logging each failed attempt,
+// or returns the last error once the next attempt would be after deadline
+func retryUntilReady(logger *log.Entry, deadline time.Time, check func() error) error {
+	backoff := readyInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := check()
+		if err == nil {
+			logger.WithField("attempt", attempt).Info("pachd is ready")
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			logger.WithField("attempt", attempt).WithError(err).Error("pachd did not become ready")
+			return fmt.Errorf("pachd not ready after %d attempts: %w", attempt, err)
+		}
+
+		logger.WithFields(log.Fields{"attempt": attempt, "retryIn": backoff}).WithError(err).Warn("pachd not ready")
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > readyMaxBackoff {
+			backoff = readyMaxBackoff
+		}
+	}
+}
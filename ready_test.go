@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryUntilReady(t *testing.T) {
+	defer func(initial, max time.Duration) {
+		readyInitialBackoff, readyMaxBackoff = initial, max
+	}(readyInitialBackoff, readyMaxBackoff)
+	readyInitialBackoff, readyMaxBackoff = time.Millisecond, 4*time.Millisecond
+
+	logger := log.WithField("addr", "test")
+	errNotReady := errors.New("not ready")
+
+	attempts := 0
+	require.NoError(t, retryUntilReady(logger, time.Now().Add(time.Minute), func() error {
+		attempts++
+		if attempts < 5 {
+			return errNotReady
+		}
+		return nil
+	}))
+	require.Equal(t, 5, attempts)
+
+	attempts = 0
+	err := retryUntilReady(logger, time.Now().Add(50*time.Millisecond), func() error {
+		attempts++
+		return errNotReady
+	})
+	require.ErrorIs(t, err, errNotReady)
+	require.True(t, attempts > 1)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pachyderm/config-pod/configpod"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultTerminationLogPath is where Kubernetes reads a container's
+// termination message from, unless its terminationMessagePath is changed
+const defaultTerminationLogPath = "/dev/termination-log"
+
+// maxTerminationMessageSize is the most Kubernetes keeps of a termination message
+const maxTerminationMessageSize = 4096
+
+// maxSummaryErrorSize limits the error in the summary, so the summary always
+// fits in a termination message
+const maxSummaryErrorSize = 2048
+
+var (
+	// reportPath is where the full JSON report of each run is written, if set
+	reportPath string
+	// terminationLogPath is where a summary of each run is written, if the
+	// file exists
+	terminationLogPath = defaultTerminationLogPath
+)
+
+// runSummary is the part of a report written to the termination log
+type runSummary struct {
+	Outcome string `json:"outcome"`
+	DryRun  bool   `json:"dryRun,omitempty"`
+	// Steps counts the steps with each outcome
+	Steps map[configpod.StepOutcome]int `json:"steps"`
+	// Items counts the changes that were applied, or in a dry run that
+	// would be, by action
+	Items        map[configpod.Action]int `json:"items"`
+	FailedStep   string                   `json:"failedStep,omitempty"`
+	FailedTarget string                   `json:"failedTarget,omitempty"`
+	Error        string                   `json:"error,omitempty"`
+}
+
+// summary summarizes the result of a run
+func summary(r *configpod.Result) runSummary {
+	s := runSummary{
+		Outcome: r.Outcome,
+		DryRun:  r.DryRun,
+		Steps:   make(map[configpod.StepOutcome]int),
+		Items:   make(map[configpod.Action]int),
+	}
+	for _, step := range r.Steps {
+		s.Steps[step.Outcome]++
+		if step.Outcome == configpod.OutcomeFailed {
+			s.FailedStep = step.Name
+			s.FailedTarget = step.Target
+			s.Error = step.Reason
+			if len(s.Error) > maxSummaryErrorSize {
+				s.Error = s.Error[:maxSummaryErrorSize] + "..."
+			}
+		}
+		for _, item := range step.Items {
+			if item.Applied || (r.DryRun && item.Action != configpod.ActionUnchanged) {
+				s.Items[item.Action]++
+			}
+		}
+	}
+	return s
+}
+
+// writeReport writes the full report to path
+func writeReport(path string, r *configpod.Result) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// writeTerminationLog writes the report's summary to path. Kubernetes creates
+// the termination log, so nothing is written if path doesn't exist, e.g.
+// when config-pod isn't running in a pod.
+func writeTerminationLog(path string, r *configpod.Result) error {
+	data, err := json.Marshal(summary(r))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxTerminationMessageSize {
+		data = data[:maxTerminationMessageSize]
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// saveReport writes the report and its summary. Failures are only logged,
+// since the run itself has already finished.
+func saveReport(r *configpod.Result) {
+	if reportPath != "" {
+		if err := writeReport(reportPath, r); err != nil {
+			log.WithError(err).WithField("path", reportPath).Warn("failed to write run report")
+		}
+	}
+	if terminationLogPath != "" {
+		if err := writeTerminationLog(terminationLogPath, r); err != nil {
+			log.WithError(err).WithField("path", terminationLogPath).Warn("failed to write termination log")
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pachyderm/config-pod/configpod"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport() *configpod.Result {
+	return &configpod.Result{
+		Outcome: "failed",
+		Steps: []configpod.StepResult{
+			{Name: "license key", Outcome: configpod.OutcomeSkipped, Reason: "skipped step - no file license", Duration: 1},
+			{Name: "sync repos", Outcome: configpod.OutcomeFailed, Reason: "repo montage not found", Duration: 2, Items: []configpod.ItemResult{
+				{Kind: "repo", ID: "images", Action: configpod.ActionCreate, Applied: true},
+				{Kind: "repo", ID: "edges", Action: configpod.ActionUnchanged},
+				{Kind: "repo", ID: "montage", Action: configpod.ActionUpdate},
+			}},
+			{Name: "sync pipelines", Outcome: configpod.OutcomeNotRun, Reason: `depends on "sync repos", which failed`},
+		},
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	require.Equal(t, runSummary{
+		Outcome:    "failed",
+		Steps:      map[configpod.StepOutcome]int{configpod.OutcomeSkipped: 1, configpod.OutcomeFailed: 1, configpod.OutcomeNotRun: 1},
+		Items:      map[configpod.Action]int{configpod.ActionCreate: 1},
+		FailedStep: "sync repos",
+		Error:      "repo montage not found",
+	}, summary(testReport()))
+}
+
+// TestTerminationLog tests that the summary is only written to an existing
+// termination log, and always fits in a termination message
+func TestTerminationLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "termination-log")
+	require.NoError(t, writeTerminationLog(path, testReport()))
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+	r := testReport()
+	r.Steps[1].Reason = strings.Repeat("x", 10*maxTerminationMessageSize)
+	require.NoError(t, writeTerminationLog(path, r))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, len(data) <= maxTerminationMessageSize)
+	var summary runSummary
+	require.NoError(t, json.Unmarshal(data, &summary))
+	require.Equal(t, "sync repos", summary.FailedStep)
+}
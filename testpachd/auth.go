@@ -0,0 +1,190 @@
+package testpachd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// roles are the roles pachd accepts in a role binding
+var roles = map[string]bool{
+	auth.ClusterAdminRole:   true,
+	auth.RepoOwnerRole:      true,
+	auth.RepoWriterRole:     true,
+	auth.RepoReaderRole:     true,
+	auth.OIDCAppAdminRole:   true,
+	auth.IDPAdminRole:       true,
+	auth.IdentityAdminRole:  true,
+	auth.DebuggerRole:       true,
+	auth.RobotUserRole:      true,
+	auth.LicenseAdminRole:   true,
+	auth.SecretAdminRole:    true,
+	auth.PachdLogReaderRole: true,
+}
+
+func resourceKey(r *auth.Resource) string {
+	return fmt.Sprintf("%s:%s", r.Type, r.Name)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type authServer struct {
+	auth.UnimplementedAPIServer
+	p *Pachd
+}
+
+func (s *authServer) Activate(ctx context.Context, req *auth.ActivateRequest) (*auth.ActivateResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.enterpriseEnabled() {
+		return nil, errors.New("error confirming Pachyderm Enterprise token: Pachyderm Enterprise is not active in this " +
+			"cluster, and the Pachyderm auth API is an Enterprise-level feature")
+	}
+	if p.authActive() {
+		return nil, auth.ErrAlreadyActivated
+	}
+
+	p.rootToken = req.RootToken
+	if p.rootToken == "" {
+		p.rootToken = randomString()
+	}
+	p.authConfig = &auth.OIDCConfig{}
+	p.roleBindings[resourceKey(&auth.Resource{Type: auth.ResourceType_CLUSTER})] = &auth.RoleBinding{
+		Entries: map[string]*auth.Roles{
+			auth.RootUser: {Roles: map[string]bool{auth.ClusterAdminRole: true}},
+		},
+	}
+	return &auth.ActivateResponse{PachToken: p.rootToken}, nil
+}
+
+func (s *authServer) Deactivate(ctx context.Context, req *auth.DeactivateRequest) (*auth.DeactivateResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	p.rootToken = ""
+	p.authConfig = nil
+	p.roleBindings = make(map[string]*auth.RoleBinding)
+	return &auth.DeactivateResponse{}, nil
+}
+
+func (s *authServer) WhoAmI(ctx context.Context, req *auth.WhoAmIRequest) (*auth.WhoAmIResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	principal, err := p.whoAmI(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.WhoAmIResponse{Username: principal}, nil
+}
+
+func (s *authServer) GetConfiguration(ctx context.Context, req *auth.GetConfigurationRequest) (*auth.GetConfigurationResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	return &auth.GetConfigurationResponse{Configuration: proto.Clone(p.authConfig).(*auth.OIDCConfig)}, nil
+}
+
+func (s *authServer) SetConfiguration(ctx context.Context, req *auth.SetConfigurationRequest) (*auth.SetConfigurationResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	if req.Configuration == nil {
+		return nil, errors.New("no configuration provided")
+	}
+	p.authConfig = req.Configuration
+	return &auth.SetConfigurationResponse{}, nil
+}
+
+func (s *authServer) GetRoleBinding(ctx context.Context, req *auth.GetRoleBindingRequest) (*auth.GetRoleBindingResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+
+	binding := &auth.RoleBinding{Entries: make(map[string]*auth.Roles)}
+	if existing, ok := p.roleBindings[resourceKey(req.Resource)]; ok {
+		binding = proto.Clone(existing).(*auth.RoleBinding)
+	}
+	return &auth.GetRoleBindingResponse{Binding: binding}, nil
+}
+
+func (s *authServer) ModifyRoleBinding(ctx context.Context, req *auth.ModifyRoleBindingRequest) (*auth.ModifyRoleBindingResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, r := range req.Roles {
+		if !roles[r] {
+			return nil, fmt.Errorf("unknown role %q", r)
+		}
+	}
+
+	binding, ok := p.roleBindings[resourceKey(req.Resource)]
+	if !ok {
+		return nil, &auth.ErrNoRoleBinding{Resource: *req.Resource}
+	}
+
+	if len(req.Roles) == 0 {
+		delete(binding.Entries, req.Principal)
+		return &auth.ModifyRoleBindingResponse{}, nil
+	}
+	entry := &auth.Roles{Roles: make(map[string]bool)}
+	for _, r := range req.Roles {
+		entry.Roles[r] = true
+	}
+	binding.Entries[req.Principal] = entry
+	return &auth.ModifyRoleBindingResponse{}, nil
+}
+
+// createRoleBinding creates the role binding for a new resource, with its
+// creator as owner if auth is active
+func (p *Pachd) createRoleBinding(ctx context.Context, resource *auth.Resource, role string) error {
+	if !p.authActive() {
+		return nil
+	}
+	principal, err := p.whoAmI(ctx)
+	if err != nil {
+		return err
+	}
+	p.roleBindings[resourceKey(resource)] = &auth.RoleBinding{
+		Entries: map[string]*auth.Roles{
+			principal: {Roles: map[string]bool{role: true}},
+		},
+	}
+	return nil
+}
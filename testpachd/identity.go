@@ -0,0 +1,318 @@
+package testpachd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/pachyderm/pachyderm/v2/src/identity"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// connectorTypes are the IDP connector types supported by the fake, which
+// unlike pachd doesn't check a connector's config beyond parsing it
+var connectorTypes = map[string]bool{
+	"mockPassword": true,
+	"oidc":         true,
+	"github":       true,
+	"gitlab":       true,
+	"ldap":         true,
+	"saml":         true,
+	"microsoft":    true,
+	"google":       true,
+}
+
+type identityServer struct {
+	identity.UnimplementedAPIServer
+	p *Pachd
+}
+
+func validateConnector(connector *identity.IDPConnector) error {
+	if !connectorTypes[connector.Type] {
+		return fmt.Errorf("unknown connector type %q", connector.Type)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(connector.JsonConfig), &config); err != nil {
+		return fmt.Errorf("unable to deserialize JSON: %w", err)
+	}
+	return nil
+}
+
+func (p *Pachd) findOIDCClient(id string) (int, *identity.OIDCClient) {
+	for i, c := range p.oidcClients {
+		if c.Id == id {
+			return i, c
+		}
+	}
+	return -1, nil
+}
+
+func (p *Pachd) findIDPConnector(id string) (int, *identity.IDPConnector) {
+	for i, c := range p.idpConnectors {
+		if c.Id == id {
+			return i, c
+		}
+	}
+	return -1, nil
+}
+
+func (s *identityServer) SetIdentityServerConfig(ctx context.Context, req *identity.SetIdentityServerConfigRequest) (*identity.SetIdentityServerConfigResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	p.identityConfig = req.Config
+	return &identity.SetIdentityServerConfigResponse{}, nil
+}
+
+func (s *identityServer) GetIdentityServerConfig(ctx context.Context, req *identity.GetIdentityServerConfigRequest) (*identity.GetIdentityServerConfigResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	config := &identity.IdentityServerConfig{}
+	if p.identityConfig != nil {
+		config = proto.Clone(p.identityConfig).(*identity.IdentityServerConfig)
+	}
+	return &identity.GetIdentityServerConfigResponse{Config: config}, nil
+}
+
+func (s *identityServer) CreateIDPConnector(ctx context.Context, req *identity.CreateIDPConnectorRequest) (*identity.CreateIDPConnectorResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+
+	connector := req.Connector
+	if connector.Id == "" {
+		return nil, errors.New("no id specified")
+	}
+	if connector.Type == "" {
+		return nil, errors.New("no type specified")
+	}
+	if connector.Name == "" {
+		return nil, errors.New("no name specified")
+	}
+	if err := validateConnector(connector); err != nil {
+		return nil, err
+	}
+	if _, c := p.findIDPConnector(connector.Id); c != nil {
+		return nil, identity.ErrAlreadyExists
+	}
+
+	p.idpConnectors = append(p.idpConnectors, connector)
+	return &identity.CreateIDPConnectorResponse{}, nil
+}
+
+func (s *identityServer) UpdateIDPConnector(ctx context.Context, req *identity.UpdateIDPConnectorRequest) (*identity.UpdateIDPConnectorResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+
+	i, existing := p.findIDPConnector(req.Connector.Id)
+	if existing == nil {
+		return nil, identity.ErrInvalidID
+	}
+	if existing.ConfigVersion+1 != req.Connector.ConfigVersion {
+		return nil, fmt.Errorf("new config version is %v, expected %v", req.Connector.ConfigVersion, existing.ConfigVersion+1)
+	}
+
+	// like pachd, empty fields are left as they are
+	updated := proto.Clone(existing).(*identity.IDPConnector)
+	updated.ConfigVersion = req.Connector.ConfigVersion
+	if req.Connector.Name != "" {
+		updated.Name = req.Connector.Name
+	}
+	if req.Connector.JsonConfig != "" && req.Connector.JsonConfig != "null" {
+		updated.JsonConfig = req.Connector.JsonConfig
+	}
+	if req.Connector.Type != "" {
+		updated.Type = req.Connector.Type
+	}
+	if err := validateConnector(updated); err != nil {
+		return nil, err
+	}
+
+	// pachd's identity database lists rows in table order, where an updated
+	// row is rewritten at the end
+	p.idpConnectors = append(append(p.idpConnectors[:i:i], p.idpConnectors[i+1:]...), updated)
+	return &identity.UpdateIDPConnectorResponse{}, nil
+}
+
+func (s *identityServer) GetIDPConnector(ctx context.Context, req *identity.GetIDPConnectorRequest) (*identity.GetIDPConnectorResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	_, c := p.findIDPConnector(req.Id)
+	if c == nil {
+		return nil, identity.ErrInvalidID
+	}
+	return &identity.GetIDPConnectorResponse{Connector: proto.Clone(c).(*identity.IDPConnector)}, nil
+}
+
+func (s *identityServer) ListIDPConnectors(ctx context.Context, req *identity.ListIDPConnectorsRequest) (*identity.ListIDPConnectorsResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	resp := &identity.ListIDPConnectorsResponse{}
+	for _, c := range p.idpConnectors {
+		resp.Connectors = append(resp.Connectors, proto.Clone(c).(*identity.IDPConnector))
+	}
+	return resp, nil
+}
+
+func (s *identityServer) DeleteIDPConnector(ctx context.Context, req *identity.DeleteIDPConnectorRequest) (*identity.DeleteIDPConnectorResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	i, c := p.findIDPConnector(req.Id)
+	if c == nil {
+		return nil, identity.ErrInvalidID
+	}
+	p.idpConnectors = append(p.idpConnectors[:i:i], p.idpConnectors[i+1:]...)
+	return &identity.DeleteIDPConnectorResponse{}, nil
+}
+
+func (s *identityServer) CreateOIDCClient(ctx context.Context, req *identity.CreateOIDCClientRequest) (*identity.CreateOIDCClientResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+
+	client := req.Client
+	if client.Name == "" {
+		return nil, errors.New("no client name specified")
+	}
+	if client.Id == "" {
+		return nil, errors.New("no client id specified")
+	}
+	if _, c := p.findOIDCClient(client.Id); c != nil {
+		return nil, identity.ErrAlreadyExists
+	}
+	if client.Secret == "" {
+		client.Secret = randomString()
+	}
+
+	p.oidcClients = append(p.oidcClients, client)
+	return &identity.CreateOIDCClientResponse{Client: proto.Clone(client).(*identity.OIDCClient)}, nil
+}
+
+func (s *identityServer) UpdateOIDCClient(ctx context.Context, req *identity.UpdateOIDCClientRequest) (*identity.UpdateOIDCClientResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+
+	i, existing := p.findOIDCClient(req.Client.Id)
+	if existing == nil {
+		return nil, fmt.Errorf("unable to find OIDC client with id %q", req.Client.Id)
+	}
+
+	// like pachd, an update can't change the client's secret
+	updated := proto.Clone(existing).(*identity.OIDCClient)
+	if req.Client.Name != "" {
+		updated.Name = req.Client.Name
+	}
+	if len(req.Client.RedirectUris) > 0 {
+		updated.RedirectUris = req.Client.RedirectUris
+	}
+	if len(req.Client.TrustedPeers) > 0 {
+		updated.TrustedPeers = req.Client.TrustedPeers
+	}
+
+	p.oidcClients = append(append(p.oidcClients[:i:i], p.oidcClients[i+1:]...), updated)
+	return &identity.UpdateOIDCClientResponse{}, nil
+}
+
+func (s *identityServer) GetOIDCClient(ctx context.Context, req *identity.GetOIDCClientRequest) (*identity.GetOIDCClientResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	_, c := p.findOIDCClient(req.Id)
+	if c == nil {
+		return nil, identity.ErrInvalidID
+	}
+	return &identity.GetOIDCClientResponse{Client: proto.Clone(c).(*identity.OIDCClient)}, nil
+}
+
+func (s *identityServer) ListOIDCClients(ctx context.Context, req *identity.ListOIDCClientsRequest) (*identity.ListOIDCClientsResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	resp := &identity.ListOIDCClientsResponse{}
+	for _, c := range p.oidcClients {
+		resp.Clients = append(resp.Clients, proto.Clone(c).(*identity.OIDCClient))
+	}
+	return resp, nil
+}
+
+func (s *identityServer) DeleteOIDCClient(ctx context.Context, req *identity.DeleteOIDCClientRequest) (*identity.DeleteOIDCClientResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	i, c := p.findOIDCClient(req.Id)
+	if c == nil {
+		return nil, fmt.Errorf("unable to find OIDC client with id %q", req.Id)
+	}
+	p.oidcClients = append(p.oidcClients[:i:i], p.oidcClients[i+1:]...)
+	return &identity.DeleteOIDCClientResponse{}, nil
+}
+
+func (s *identityServer) DeleteAll(ctx context.Context, req *identity.DeleteAllRequest) (*identity.DeleteAllResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	p.identityConfig = nil
+	p.oidcClients = nil
+	p.idpConnectors = nil
+	return &identity.DeleteAllResponse{}, nil
+}
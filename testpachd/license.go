@@ -0,0 +1,279 @@
+package testpachd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pachyderm/pachyderm/v2/src/enterprise"
+	"github.com/pachyderm/pachyderm/v2/src/license"
+
+	"github.com/gogo/protobuf/types"
+)
+
+func (p *Pachd) findCluster(id string) (int, *license.AddClusterRequest) {
+	for i, c := range p.clusters {
+		if c.Id == id {
+			return i, c
+		}
+	}
+	return -1, nil
+}
+
+// rewriteCluster moves the i'th cluster to the end of the list. pachd lists
+// clusters in table order, where an updated row is rewritten at the end.
+func (p *Pachd) rewriteCluster(i int) {
+	c := p.clusters[i]
+	p.clusters = append(append(p.clusters[:i:i], p.clusters[i+1:]...), c)
+}
+
+// licenseState is the state of the license server's activation code
+func (p *Pachd) licenseState() enterprise.State {
+	if p.activationCode == "" {
+		return enterprise.State_NONE
+	}
+	return enterprise.State_ACTIVE
+}
+
+// heartbeat checks a cluster's credentials with the license server, as
+// pachd does when enterprise is activated
+func (p *Pachd) heartbeat(id, secret string) error {
+	if _, c := p.findCluster(id); c == nil || c.Secret != secret {
+		return license.ErrInvalidIDOrSecret
+	}
+	if p.licenseState() != enterprise.State_ACTIVE {
+		return fmt.Errorf("enterprise license is not valid - %v", p.licenseState())
+	}
+	return nil
+}
+
+// enterpriseState is the enterprise state of this pachd
+func (p *Pachd) enterpriseState() enterprise.State {
+	if p.enterpriseConfig == nil {
+		return enterprise.State_NONE
+	}
+	if err := p.heartbeat(p.enterpriseConfig.Id, p.enterpriseConfig.Secret); err != nil {
+		return enterprise.State_HEARTBEAT_FAILED
+	}
+	return enterprise.State_ACTIVE
+}
+
+// enterpriseEnabled returns true if enterprise features are enabled. Before
+// enterprise is configured, they're enabled by an active license in this
+// pachd's own license server, like a pachd deployed with a license key.
+func (p *Pachd) enterpriseEnabled() bool {
+	if p.enterpriseConfig == nil {
+		return p.licenseState() == enterprise.State_ACTIVE
+	}
+	return p.enterpriseState() == enterprise.State_ACTIVE
+}
+
+type licenseServer struct {
+	license.UnimplementedAPIServer
+	p *Pachd
+}
+
+func (s *licenseServer) Activate(ctx context.Context, req *license.ActivateRequest) (*license.ActivateResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.ActivationCode == "" {
+		return nil, errors.New("error validating activation code: activation code is empty")
+	}
+	p.activationCode = req.ActivationCode
+	return &license.ActivateResponse{Info: &enterprise.TokenInfo{Expires: req.Expires}}, nil
+}
+
+func (s *licenseServer) GetActivationCode(ctx context.Context, req *license.GetActivationCodeRequest) (*license.GetActivationCodeResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return &license.GetActivationCodeResponse{
+		State:          p.licenseState(),
+		ActivationCode: p.activationCode,
+	}, nil
+}
+
+func (s *licenseServer) DeleteAll(ctx context.Context, req *license.DeleteAllRequest) (*license.DeleteAllResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	p.activationCode = ""
+	p.clusters = nil
+	return &license.DeleteAllResponse{}, nil
+}
+
+func (s *licenseServer) AddCluster(ctx context.Context, req *license.AddClusterRequest) (*license.AddClusterResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if p.licenseState() != enterprise.State_ACTIVE {
+		return nil, fmt.Errorf("enterprise license is not valid - %v", p.licenseState())
+	}
+	if req.Id == "" {
+		return nil, errors.New("no id provided for cluster")
+	}
+	if req.Address == "" {
+		return nil, errors.New("no address provided for cluster")
+	}
+	if _, c := p.findCluster(req.Id); c != nil {
+		return nil, license.ErrDuplicateClusterID
+	}
+
+	c := req
+	if c.Secret == "" {
+		c.Secret = randomString()
+	}
+	p.clusters = append(p.clusters, c)
+	return &license.AddClusterResponse{Secret: c.Secret}, nil
+}
+
+func (s *licenseServer) UpdateCluster(ctx context.Context, req *license.UpdateClusterRequest) (*license.UpdateClusterResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Address == "" && req.UserAddress == "" && req.ClusterDeploymentId == "" {
+		return nil, errors.New("No cluster fields were provided to the UpdateCluster RPC")
+	}
+
+	// like an UPDATE in pachd's database, an unknown id is not an error
+	i, c := p.findCluster(req.Id)
+	if c == nil {
+		return &license.UpdateClusterResponse{}, nil
+	}
+	if req.Address != "" {
+		c.Address = req.Address
+	}
+	if req.UserAddress != "" {
+		c.UserAddress = req.UserAddress
+	}
+	if req.ClusterDeploymentId != "" {
+		c.ClusterDeploymentId = req.ClusterDeploymentId
+	}
+
+	p.rewriteCluster(i)
+	return &license.UpdateClusterResponse{}, nil
+}
+
+func (s *licenseServer) DeleteCluster(ctx context.Context, req *license.DeleteClusterRequest) (*license.DeleteClusterResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if i, c := p.findCluster(req.Id); c != nil {
+		p.clusters = append(p.clusters[:i:i], p.clusters[i+1:]...)
+	}
+	return &license.DeleteClusterResponse{}, nil
+}
+
+func (s *licenseServer) ListClusters(ctx context.Context, req *license.ListClustersRequest) (*license.ListClustersResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &license.ListClustersResponse{Clusters: make([]*license.ClusterStatus, 0, len(p.clusters))}
+	for _, c := range p.clusters {
+		resp.Clusters = append(resp.Clusters, &license.ClusterStatus{
+			Id:      c.Id,
+			Address: c.Address,
+			Version: "unknown",
+		})
+	}
+	return resp, nil
+}
+
+func (s *licenseServer) ListUserClusters(ctx context.Context, req *license.ListUserClustersRequest) (*license.ListUserClustersResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resp := &license.ListUserClustersResponse{Clusters: make([]*license.UserClusterInfo, 0, len(p.clusters))}
+	for _, c := range p.clusters {
+		if c.EnterpriseServer {
+			continue
+		}
+		resp.Clusters = append(resp.Clusters, &license.UserClusterInfo{
+			Id:                  c.Id,
+			ClusterDeploymentId: c.ClusterDeploymentId,
+			Address:             c.UserAddress,
+			EnterpriseServer:    c.EnterpriseServer,
+		})
+	}
+	return resp, nil
+}
+
+type enterpriseServer struct {
+	enterprise.UnimplementedAPIServer
+	p *Pachd
+}
+
+func (s *enterpriseServer) Activate(ctx context.Context, req *enterprise.ActivateRequest) (*enterprise.ActivateResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	// pachd heartbeats to the license server before saving the config. The
+	// fake is always its own license server, whatever LicenseServer says.
+	if err := p.heartbeat(req.Id, req.Secret); err != nil {
+		return nil, err
+	}
+	// the license server records the heartbeat in the cluster's row
+	i, _ := p.findCluster(req.Id)
+	p.rewriteCluster(i)
+	p.enterpriseConfig = req
+	return &enterprise.ActivateResponse{}, nil
+}
+
+func (s *enterpriseServer) GetState(ctx context.Context, req *enterprise.GetStateRequest) (*enterprise.GetStateResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resp := &enterprise.GetStateResponse{State: p.enterpriseState()}
+	if resp.State == enterprise.State_ACTIVE {
+		resp.Info = &enterprise.TokenInfo{Expires: &types.Timestamp{Seconds: 1 << 40}}
+	}
+	return resp, nil
+}
+
+func (s *enterpriseServer) Deactivate(ctx context.Context, req *enterprise.DeactivateRequest) (*enterprise.DeactivateResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	p.enterpriseConfig = nil
+	return &enterprise.DeactivateResponse{}, nil
+}
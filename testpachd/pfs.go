@@ -0,0 +1,305 @@
+package testpachd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+	"github.com/pachyderm/pachyderm/v2/src/pfs"
+	"github.com/pachyderm/pachyderm/v2/src/pps"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+)
+
+func (p *Pachd) findRepo(name string) *pfs.RepoInfo {
+	for _, r := range p.repos {
+		if r.Repo.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (p *Pachd) findPipeline(name string) *pps.PipelineInfo {
+	for _, pi := range p.pipelines {
+		if pi.Pipeline.Name == name {
+			return pi
+		}
+	}
+	return nil
+}
+
+// createRepo creates a user repo, owned by the caller if auth is active
+func (p *Pachd) createRepo(ctx context.Context, name, description string) (*pfs.RepoInfo, error) {
+	if name == "" {
+		return nil, errors.New("repo name must not be empty")
+	}
+	if err := p.createRoleBinding(ctx, &auth.Resource{Type: auth.ResourceType_REPO, Name: name}, auth.RepoOwnerRole); err != nil {
+		return nil, err
+	}
+	info := &pfs.RepoInfo{
+		Repo:        &pfs.Repo{Name: name, Type: pfs.UserRepoType},
+		Created:     types.TimestampNow(),
+		Description: description,
+	}
+	p.repos = append(p.repos, info)
+	return info, nil
+}
+
+type pfsServer struct {
+	pfs.UnimplementedAPIServer
+	p *Pachd
+}
+
+func (s *pfsServer) ActivateAuth(ctx context.Context, req *pfs.ActivateAuthRequest) (*pfs.ActivateAuthResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+
+	// repos created before auth was active get an empty role binding
+	for _, r := range p.repos {
+		key := resourceKey(&auth.Resource{Type: auth.ResourceType_REPO, Name: r.Repo.Name})
+		if _, ok := p.roleBindings[key]; !ok {
+			p.roleBindings[key] = &auth.RoleBinding{Entries: make(map[string]*auth.Roles)}
+		}
+	}
+	return &pfs.ActivateAuthResponse{}, nil
+}
+
+func (s *pfsServer) CreateRepo(ctx context.Context, req *pfs.CreateRepoRequest) (*types.Empty, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.authActive() {
+		if _, err := p.whoAmI(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if existing := p.findRepo(req.Repo.Name); existing != nil {
+		if !req.Update {
+			return nil, fmt.Errorf("repo %s already exists", req.Repo.Name)
+		}
+		existing.Description = req.Description
+		return &types.Empty{}, nil
+	}
+
+	if _, err := p.createRepo(ctx, req.Repo.Name, req.Description); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (s *pfsServer) InspectRepo(ctx context.Context, req *pfs.InspectRepoRequest) (*pfs.RepoInfo, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.authActive() {
+		if _, err := p.whoAmI(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	info := p.findRepo(req.Repo.Name)
+	if info == nil {
+		return nil, fmt.Errorf("repo %s not found", req.Repo.Name)
+	}
+	return proto.Clone(info).(*pfs.RepoInfo), nil
+}
+
+func (s *pfsServer) ListRepo(req *pfs.ListRepoRequest, server pfs.API_ListRepoServer) error {
+	p := s.p
+	p.mu.Lock()
+	var infos []*pfs.RepoInfo
+	for _, r := range p.repos {
+		if req.Type == "" || req.Type == r.Repo.Type {
+			infos = append(infos, proto.Clone(r).(*pfs.RepoInfo))
+		}
+	}
+	p.mu.Unlock()
+
+	for _, info := range infos {
+		if err := server.Send(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pfsServer) DeleteAll(ctx context.Context, req *types.Empty) (*types.Empty, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	for _, r := range p.repos {
+		delete(p.roleBindings, resourceKey(&auth.Resource{Type: auth.ResourceType_REPO, Name: r.Repo.Name}))
+	}
+	p.repos = nil
+	return &types.Empty{}, nil
+}
+
+type ppsServer struct {
+	pps.UnimplementedAPIServer
+	p *Pachd
+}
+
+func (s *ppsServer) ActivateAuth(ctx context.Context, req *pps.ActivateAuthRequest) (*pps.ActivateAuthResponse, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.whoAmI(ctx); err != nil {
+		return nil, err
+	}
+	return &pps.ActivateAuthResponse{}, nil
+}
+
+// inputRepos returns the names of the pfs repos an input reads from
+func inputRepos(input *pps.Input) []string {
+	if input == nil {
+		return nil
+	}
+	var repos []string
+	if input.Pfs != nil {
+		repos = append(repos, input.Pfs.Repo)
+	}
+	for _, inputs := range [][]*pps.Input{input.Join, input.Group, input.Cross, input.Union} {
+		for _, i := range inputs {
+			repos = append(repos, inputRepos(i)...)
+		}
+	}
+	return repos
+}
+
+func (s *ppsServer) CreatePipeline(ctx context.Context, req *pps.CreatePipelineRequest) (*types.Empty, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.authActive() {
+		if _, err := p.whoAmI(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Pipeline == nil || req.Pipeline.Name == "" {
+		return nil, errors.New("invalid pipeline spec: request.Pipeline cannot be nil")
+	}
+	if req.Transform == nil || req.Transform.Image == "" {
+		return nil, errors.New("invalid pipeline spec: transform.image cannot be empty")
+	}
+	for _, repo := range inputRepos(req.Input) {
+		if p.findRepo(repo) == nil {
+			return nil, fmt.Errorf("input repo %s not found", repo)
+		}
+	}
+
+	name := req.Pipeline.Name
+	existing := p.findPipeline(name)
+	if existing != nil && !req.Update {
+		return nil, fmt.Errorf("pipeline %q already exists", name)
+	}
+	if existing == nil && p.findRepo(name) == nil {
+		if _, err := p.createRepo(ctx, name, fmt.Sprintf("Output repo for pipeline %s.", name)); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &pps.PipelineInfo{
+		Pipeline: req.Pipeline,
+		Version:  1,
+		State:    pps.PipelineState_PIPELINE_RUNNING,
+		Type:     pps.PipelineInfo_PIPELINE_TYPE_TRANSFORM,
+		Details: &pps.PipelineInfo_Details{
+			Transform:       req.Transform,
+			ParallelismSpec: req.ParallelismSpec,
+			Egress:          req.Egress,
+			CreatedAt:       types.TimestampNow(),
+			OutputBranch:    req.OutputBranch,
+			Input:           req.Input,
+			Description:     req.Description,
+			Salt:            req.Salt,
+			Service:         req.Service,
+			Spout:           req.Spout,
+			Metadata:        req.Metadata,
+			PodSpec:         req.PodSpec,
+			PodPatch:        req.PodPatch,
+			S3Out:           req.S3Out,
+			Autoscaling:     req.Autoscaling,
+		},
+	}
+	if existing != nil {
+		info.Version = existing.Version + 1
+		*existing = *info
+		return &types.Empty{}, nil
+	}
+	p.pipelines = append(p.pipelines, info)
+	return &types.Empty{}, nil
+}
+
+// pipelineInfo returns a copy of info, without details unless they're requested
+func pipelineInfo(info *pps.PipelineInfo, details bool) *pps.PipelineInfo {
+	info = proto.Clone(info).(*pps.PipelineInfo)
+	if !details {
+		info.Details = nil
+	}
+	return info
+}
+
+func (s *ppsServer) InspectPipeline(ctx context.Context, req *pps.InspectPipelineRequest) (*pps.PipelineInfo, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.authActive() {
+		if _, err := p.whoAmI(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	info := p.findPipeline(req.Pipeline.Name)
+	if info == nil {
+		return nil, fmt.Errorf("pipeline %q not found", req.Pipeline.Name)
+	}
+	return pipelineInfo(info, req.Details), nil
+}
+
+func (s *ppsServer) ListPipeline(req *pps.ListPipelineRequest, server pps.API_ListPipelineServer) error {
+	p := s.p
+	p.mu.Lock()
+	var infos []*pps.PipelineInfo
+	for _, info := range p.pipelines {
+		infos = append(infos, pipelineInfo(info, req.Details))
+	}
+	p.mu.Unlock()
+
+	for _, info := range infos {
+		if err := server.Send(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ppsServer) DeleteAll(ctx context.Context, req *types.Empty) (*types.Empty, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	p.pipelines = nil
+	return &types.Empty{}, nil
+}
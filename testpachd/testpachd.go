@@ -0,0 +1,166 @@
+// Package testpachd is an in-memory stand-in for pachd, for testing
+// config-pod without a cluster. It implements the parts of the auth, identity,
+// license, enterprise, pfs and pps APIs which config-pod uses, and returns the
+// same errors as pachd for the cases config-pod handles, such as a duplicate
+// cluster ID or an already activated auth service.
+package testpachd
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/pachyderm/pachyderm/v2/src/admin"
+	"github.com/pachyderm/pachyderm/v2/src/auth"
+	"github.com/pachyderm/pachyderm/v2/src/client"
+	"github.com/pachyderm/pachyderm/v2/src/debug"
+	"github.com/pachyderm/pachyderm/v2/src/enterprise"
+	"github.com/pachyderm/pachyderm/v2/src/identity"
+	"github.com/pachyderm/pachyderm/v2/src/license"
+	"github.com/pachyderm/pachyderm/v2/src/pfs"
+	"github.com/pachyderm/pachyderm/v2/src/pps"
+	"github.com/pachyderm/pachyderm/v2/src/proxy"
+	"github.com/pachyderm/pachyderm/v2/src/transaction"
+	"github.com/pachyderm/pachyderm/v2/src/version"
+	"github.com/pachyderm/pachyderm/v2/src/version/versionpb"
+
+	"github.com/gogo/protobuf/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Pachd is an in-memory pachd served over an in-process gRPC connection.
+// All of its services share one lock, because like pachd's services they
+// depend on each other's state, e.g. creating a repo creates its role binding.
+type Pachd struct {
+	mu sync.Mutex
+
+	// license server
+	activationCode string
+	clusters       []*license.AddClusterRequest
+
+	// enterprise
+	enterpriseConfig *enterprise.ActivateRequest
+
+	// auth
+	rootToken    string
+	authConfig   *auth.OIDCConfig
+	roleBindings map[string]*auth.RoleBinding
+
+	// identity
+	identityConfig *identity.IdentityServerConfig
+	oidcClients    []*identity.OIDCClient
+	idpConnectors  []*identity.IDPConnector
+
+	// pfs and pps
+	repos     []*pfs.RepoInfo
+	pipelines []*pps.PipelineInfo
+
+	server   *grpc.Server
+	listener *bufconn.Listener
+	conn     *grpc.ClientConn
+}
+
+// New starts a Pachd with no state, as if it had just been deployed
+func New() (*Pachd, error) {
+	p := &Pachd{
+		roleBindings: make(map[string]*auth.RoleBinding),
+		server:       grpc.NewServer(),
+		listener:     bufconn.Listen(1 << 20),
+	}
+
+	auth.RegisterAPIServer(p.server, &authServer{p: p})
+	identity.RegisterAPIServer(p.server, &identityServer{p: p})
+	license.RegisterAPIServer(p.server, &licenseServer{p: p})
+	enterprise.RegisterAPIServer(p.server, &enterpriseServer{p: p})
+	pfs.RegisterAPIServer(p.server, &pfsServer{p: p})
+	pps.RegisterAPIServer(p.server, &ppsServer{p: p})
+	versionpb.RegisterAPIServer(p.server, &versionServer{})
+	transaction.RegisterAPIServer(p.server, &transactionServer{})
+	go p.server.Serve(p.listener)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return p.listener.Dial()
+		}),
+	)
+	if err != nil {
+		p.server.Stop()
+		return nil, err
+	}
+	p.conn = conn
+	return p, nil
+}
+
+// Client returns a new client for p, with no auth token set. The client's
+// Health and file methods aren't supported.
+func (p *Pachd) Client() *client.APIClient {
+	return &client.APIClient{
+		PfsAPIClient:         pfs.NewAPIClient(p.conn),
+		PpsAPIClient:         pps.NewAPIClient(p.conn),
+		AuthAPIClient:        auth.NewAPIClient(p.conn),
+		IdentityAPIClient:    identity.NewAPIClient(p.conn),
+		VersionAPIClient:     versionpb.NewAPIClient(p.conn),
+		AdminAPIClient:       admin.NewAPIClient(p.conn),
+		TransactionAPIClient: transaction.NewAPIClient(p.conn),
+		DebugClient:          debug.NewDebugClient(p.conn),
+		ProxyClient:          proxy.NewAPIClient(p.conn),
+		Enterprise:           enterprise.NewAPIClient(p.conn),
+		License:              license.NewAPIClient(p.conn),
+	}
+}
+
+// Close stops p and closes its connection
+func (p *Pachd) Close() error {
+	err := p.conn.Close()
+	p.server.Stop()
+	return err
+}
+
+// authActive returns true if the auth service has been activated
+func (p *Pachd) authActive() bool {
+	return p.rootToken != ""
+}
+
+// whoAmI returns the principal authenticated by the token in ctx, which is
+// only ever the root user because the fake doesn't issue other tokens
+func (p *Pachd) whoAmI(ctx context.Context) (string, error) {
+	if !p.authActive() {
+		return "", auth.ErrNotActivated
+	}
+	token, err := auth.GetAuthToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	if token != p.rootToken {
+		return "", auth.ErrBadToken
+	}
+	return auth.RootUser, nil
+}
+
+// checkAdmin returns an error if auth is active and ctx isn't authenticated
+// as a cluster admin. Admin-only APIs are open while auth is inactive.
+func (p *Pachd) checkAdmin(ctx context.Context) error {
+	if !p.authActive() {
+		return nil
+	}
+	_, err := p.whoAmI(ctx)
+	return err
+}
+
+type versionServer struct {
+	versionpb.UnimplementedAPIServer
+}
+
+func (s *versionServer) GetVersion(context.Context, *types.Empty) (*versionpb.Version, error) {
+	return version.Version, nil
+}
+
+type transactionServer struct {
+	transaction.UnimplementedAPIServer
+}
+
+func (s *transactionServer) DeleteAll(context.Context, *transaction.DeleteAllRequest) (*types.Empty, error) {
+	return &types.Empty{}, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pachyderm/config-pod/configpod"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPollInterval   = 5 * time.Second
+	defaultResyncInterval = 10 * time.Minute
+)
+
+// configFingerprint hashes the names and contents of every file under root.
+//
+// Kubernetes updates a mounted Secret by writing a new timestamped directory
+// and atomically swapping the `..data` symlink to it, so the files are read
+// through their top-level links (which point into `..data`) and the
+// dot-dot entries themselves are ignored.
+func configFingerprint(root string) (string, error) {
+	h := sha256.New()
+	if err := hashDir(h, root, ""); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashDir(h io.Writer, root, rel string) error {
+	entries, err := ioutil.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "..") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(rel, name)
+		// stat rather than using the ReadDir result, to follow symlinks
+		info, err := os.Stat(filepath.Join(root, path))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			if err := hashDir(h, root, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%s\x00%x\n", path, sum)
+	}
+	return nil
+}
+
+// watchConfig re-runs the sync steps whenever the contents of the config
+// root change, and at least once every resyncInterval, until ctx is done.
+// Failed syncs are logged and retried on the next change or resync.
+func watchConfig(ctx context.Context, syncer *configpod.Syncer, root string, pollInterval, resyncInterval time.Duration) {
+	log.WithFields(log.Fields{
+		"root":           root,
+		"pollInterval":   pollInterval,
+		"resyncInterval": resyncInterval,
+	}).Info("watching config for changes")
+
+	var lastFingerprint string
+	var lastSync time.Time
+	for {
+		fingerprint, err := configFingerprint(root)
+		if err != nil {
+			log.WithError(err).Warn("failed to read config root")
+		} else if fingerprint != lastFingerprint || time.Since(lastSync) >= resyncInterval {
+			if fingerprint != lastFingerprint {
+				log.Info("config changed, syncing")
+			} else {
+				log.Info("resync interval elapsed, syncing")
+			}
+
+			if _, err := runSync(ctx, syncer); err != nil {
+				log.WithError(err).Error("sync failed, will retry on the next change or resync")
+			} else {
+				log.Info("sync complete")
+			}
+			lastFingerprint = fingerprint
+			lastSync = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("stopped watching config")
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigFingerprintSymlinkSwap tests that swapping the ..data symlink, as
+// Kubernetes does when a mounted Secret is updated, changes the fingerprint
+func TestConfigFingerprintSymlinkSwap(t *testing.T) {
+	root, err := ioutil.TempDir("", "watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	writeVersion := func(dir, token string) {
+		require.NoError(t, os.Mkdir(filepath.Join(root, dir), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, dir, "rootToken"), []byte(token), 0644))
+	}
+
+	writeVersion("..v1", "first")
+	require.NoError(t, os.Symlink("..v1", filepath.Join(root, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "rootToken"), filepath.Join(root, "rootToken")))
+
+	first, err := configFingerprint(root)
+	require.NoError(t, err)
+
+	again, err := configFingerprint(root)
+	require.NoError(t, err)
+	require.Equal(t, first, again)
+
+	writeVersion("..v2", "second")
+	require.NoError(t, os.Symlink("..v2", filepath.Join(root, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(root, "..data_tmp"), filepath.Join(root, "..data")))
+
+	second, err := configFingerprint(root)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+}